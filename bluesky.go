@@ -1,121 +1,251 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"log"
+	"slices"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/xrpc"
 )
 
-const (
-	apiEntryway = "https://bsky.social"
-	apiPublic   = "https://public.api.bsky.app"
-)
-
 type blueskyClient struct {
-	xrpcClient *xrpc.Client
+	xrpcClient *blueskySession
 	did        string
 	blueskyClientConfig
 }
 
 type blueskyClientConfig struct {
-	handle     string
-	appkey     string
-	dryRun     bool
-	maxPosts   int
-	newsFilter map[string]func(newsEntry) bool
+	handle string
+	appkey string
+	// sessionCache is the file the session is kept in between runs.
+	sessionCache string
+	// entryway is the server the client talks to. If empty, it talks to the
+	// PDS of the account.
+	entryway string
+	// directory resolves the handle to the DID document of the account.
+	// Defaults to DNS, HTTP and the PLC directory.
+	directory identity.Directory
+	// linkCards fetches the link cards of the first posts of threads.
+	linkCards *linkCardFetcher
+	clientOptions
+}
+
+var blueskyPlatform = platform{
+	name:         "bluesky",
+	editStrategy: editStrategyReply,
+	settings: []platformSetting{
+		{name: "handle"},
+		{name: "app_password", secret: true},
+		{name: "session_cache", optional: true},
+		{name: "entryway", optional: true},
+	},
+	newClient: func(ctx context.Context, settings map[string]string, opts clientOptions) (postingClient, error) {
+		return newBlueskyClient(ctx, blueskyClientConfig{
+			handle:        settings["handle"],
+			appkey:        settings["app_password"],
+			sessionCache:  settings["session_cache"],
+			entryway:      settings["entryway"],
+			clientOptions: opts,
+		})
+	},
 }
 
 func newBlueskyClient(ctx context.Context, conf blueskyClientConfig) (*blueskyClient, error) {
-	client := &blueskyClient{
-		xrpcClient: &xrpc.Client{
-			Client: &http.Client{},
-			Host:   string(apiEntryway),
-		},
-		blueskyClientConfig: conf,
+	if conf.directory == nil {
+		conf.directory = newIdentityDirectory()
+	}
+	if conf.linkCards == nil {
+		conf.linkCards = newLinkCardFetcher(nil)
 	}
+	client := &blueskyClient{blueskyClientConfig: conf}
 
-	did, err := client.resolveHandle(ctx, client.handle)
+	did, pds, err := resolvePDS(ctx, conf.directory, conf.handle)
 	if err != nil {
-		return nil, fmt.Errorf("resolving handle %q: %w", client.handle, err)
+		return nil, fmt.Errorf("resolving handle %q: %w", conf.handle, err)
 	}
 	client.did = did
 
-	session, err := atproto.ServerCreateSession(ctx, client.xrpcClient, &atproto.ServerCreateSession_Input{
-		Identifier: client.handle,
-		Password:   client.appkey,
-	})
+	host := pds
+	if conf.entryway != "" {
+		host = strings.TrimSuffix(conf.entryway, "/")
+	}
+	log.Printf("Using Bluesky server %s for %s", host, did)
+	xrpcClient := &xrpc.Client{
+		Client: newRetryClient(),
+		Host:   host,
+	}
+
+	session, err := newBlueskySession(ctx, xrpcClient, client.handle, client.appkey, client.sessionCache)
 	if err != nil {
-		return nil, fmt.Errorf("creating authenticated session: %w", err)
+		return nil, err
 	}
-	client.xrpcClient.Auth = &xrpc.AuthInfo{AccessJwt: session.AccessJwt}
+	client.xrpcClient = session
 
 	return client, nil
 }
 
-func (c *blueskyClient) resolveHandle(ctx context.Context, handle string) (string, error) {
-	handle = strings.TrimPrefix(handle, "@")
-	output, err := atproto.IdentityResolveHandle(ctx, c.xrpcClient, handle)
+func newIdentityDirectory() *identity.BaseDirectory {
+	httpClient := newRetryClient()
+	httpClient.Timeout = 30 * time.Second
+	return &identity.BaseDirectory{
+		PLCURL:              identity.DefaultPLCURL,
+		HTTPClient:          *httpClient,
+		TryAuthoritativeDNS: true,
+		// The Bluesky PDS only supports resolution over HTTP.
+		SkipDNSDomainSuffixes: []string{".bsky.social"},
+	}
+}
+
+// resolvePDS resolves the handle with DNS or its well-known DID and returns
+// the DID and the PDS from the DID document.
+func resolvePDS(ctx context.Context, dir identity.Directory, handle string) (string, string, error) {
+	h, err := syntax.ParseHandle(strings.TrimPrefix(handle, "@"))
 	if err != nil {
-		return "", fmt.Errorf("ResolveHandle error: %v", err)
+		return "", "", err
+	}
+	ident, err := dir.LookupHandle(ctx, h)
+	if err != nil {
+		return "", "", err
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return "", "", fmt.Errorf("DID document of %s has no PDS", ident.DID)
 	}
-	return output.Did, nil
+	return ident.DID.String(), strings.TrimSuffix(pds, "/"), nil
 }
 
+// ListPosts lists the posts of the account created within the list window.
+// With a post cache, listing stops at the first cached post.
 func (c *blueskyClient) ListPosts(ctx context.Context) ([]post, error) {
-	var posts []post
+	cache := readPostCache[*blueskyPost](c.postCachePath)
+	cutoff := c.listCutoff()
+
+	var listed []*blueskyPost
 	cursor := ""
 	for {
-		resp, err := bsky.FeedGetAuthorFeed(ctx, c.xrpcClient, c.did, cursor, "", false, int64(100))
-		if err != nil {
+		params := map[string]any{
+			"actor": c.did,
+			// Replies to others aren't part of news threads.
+			"filter":      "posts_and_author_threads",
+			"includePins": false,
+			"limit":       100,
+		}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var resp authorFeed
+		if err := c.xrpcClient.LexDo(ctx, lexutil.Query, "", "app.bsky.feed.getAuthorFeed", params, nil, &resp); err != nil {
 			return nil, fmt.Errorf("getting author feed: %w", err)
 		}
-		if resp == nil {
-			return nil, fmt.Errorf("received nil response from author feed")
+		posts := resp.posts(c.did)
+		listed = append(listed, posts...)
+		if resp.Cursor == "" {
+			break // if no cursor returned, we're done
 		}
-		if resp.Feed == nil {
-			return nil, fmt.Errorf("received nil feed from author feed response")
+		if slices.ContainsFunc(posts, func(p *blueskyPost) bool { return cache.contains(p.ID()) }) {
+			break // the older posts are cached
 		}
-		for _, entry := range resp.Feed {
-			rec, ok := entry.Post.Record.Val.(*bsky.FeedPost)
-			if !ok {
-				return nil, fmt.Errorf("unexpected record type in feed post")
-			}
-			posts = append(posts, &blueskyPost{rec})
+		// Posts are listed from newest to oldest.
+		if len(posts) > 0 && isBefore(posts[len(posts)-1].postedAt(), cutoff) {
+			break
 		}
-		if resp.Cursor == nil || *resp.Cursor == "" {
-			break // if no cursor returned, we're done
+		cursor = resp.Cursor
+	}
+	log.Printf("Listed %d new posts, %d posts cached", len(listed), len(cache.Posts))
+
+	cache.update(listed, cutoff)
+	cache.write(c.postCachePath)
+	return cache.posts(), nil
+}
+
+// authorFeed is the response of app.bsky.feed.getAuthorFeed. Records are
+// decoded one by one, so that a record of an unknown type doesn't fail the
+// whole listing, as it does with bsky.FeedGetAuthorFeed.
+type authorFeed struct {
+	Cursor string `json:"cursor"`
+	Feed   []struct {
+		Post struct {
+			URI    string `json:"uri"`
+			CID    string `json:"cid"`
+			Author struct {
+				DID string `json:"did"`
+			} `json:"author"`
+			Record json.RawMessage `json:"record"`
+		} `json:"post"`
+		Reason *struct {
+			Type string `json:"$type"`
+		} `json:"reason"`
+	} `json:"feed"`
+}
+
+// posts returns the posts of the account in the feed. Reposts and records
+// other than posts are skipped.
+func (f *authorFeed) posts(did string) []*blueskyPost {
+	var posts []*blueskyPost
+	for _, item := range f.Feed {
+		if item.Reason != nil {
+			// Reposts, and pinned posts that are listed again.
+			continue
 		}
-		cursor = *resp.Cursor
+		if item.Post.Author.DID != did {
+			continue
+		}
+		recordType, err := lexutil.TypeExtract(item.Post.Record)
+		if err != nil {
+			log.Printf("Warn: skipping record %s: %v", item.Post.URI, err)
+			continue
+		}
+		if recordType != "app.bsky.feed.post" {
+			log.Printf("Warn: skipping record %s of unknown type %q", item.Post.URI, recordType)
+			continue
+		}
+		var rec bsky.FeedPost
+		if err := json.Unmarshal(item.Post.Record, &rec); err != nil {
+			log.Printf("Warn: skipping post %s: %v", item.Post.URI, err)
+			continue
+		}
+		posts = append(posts, &blueskyPost{FeedPost: &rec, uri: item.Post.URI, cid: item.Post.CID})
 	}
-	return posts, nil
+	return posts
 }
 
-func (c *blueskyClient) CreatePostChain(ctx context.Context, postChain []string) error {
+func (c *blueskyClient) CreatePostChain(ctx context.Context, postChain []string, inReplyTo string) ([]string, error) {
 	if c.dryRun {
-		return nil
+		return nil, nil
 	}
 
+	var uris []string
 	var parentURI, parentCID, rootURI, rootCID string
+	if inReplyTo != "" {
+		parent, root, err := c.replyRefs(ctx, inReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("getting post %s to reply to: %w", inReplyTo, err)
+		}
+		parentURI, parentCID, rootURI, rootCID = parent.Uri, parent.Cid, root.Uri, root.Cid
+	}
 	for i, post := range postChain {
 		post := &bsky.FeedPost{
 			Text:      post,
 			CreatedAt: time.Now().UTC().Format(time.RFC3339),
 			Langs:     []string{"en"},
-			Facets: append(
-				hashtagFacetsFromString(post),
-				linkFacetsFromString(post)...,
-			),
+			Facets:    buildFacets(ctx, post, c.optionsSearch, c.resolveHandle),
+		}
+		// Replies, like updates of a thread, don't repeat the card.
+		if i == 0 && inReplyTo == "" {
+			post.Embed = c.linkCardEmbed(ctx, postChain)
 		}
 
-		if i > 0 {
+		if parentURI != "" {
 			post.Reply = &bsky.FeedPost_ReplyRef{
 				Parent: &atproto.RepoStrongRef{
 					Uri: parentURI,
@@ -135,37 +265,158 @@ func (c *blueskyClient) CreatePostChain(ctx context.Context, postChain []string)
 		}
 		out, err := atproto.RepoCreateRecord(ctx, c.xrpcClient, in)
 		if err != nil {
-			return fmt.Errorf("failed to create post %d: %w", i, err)
+			return uris, fmt.Errorf("failed to create post %d: %w", i, err)
 		}
+		uris = append(uris, out.Uri)
 
 		parentURI, parentCID = out.Uri, out.Cid
-		if i == 0 {
+		if rootURI == "" {
 			rootURI, rootCID = out.Uri, out.Cid
 		}
 		time.Sleep(2 * time.Second)
 	}
 
-	return nil
+	return uris, nil
 }
 
-func (c *blueskyClient) NewsFilter() map[string]func(newsEntry) bool {
-	return c.newsFilter
+// linkCardEmbed returns the card of the first link in the posts, or nil if
+// there is no link or its card can't be fetched.
+func (c *blueskyClient) linkCardEmbed(ctx context.Context, postChain []string) *bsky.FeedPost_Embed {
+	uri := firstLink(postChain)
+	if uri == "" {
+		return nil
+	}
+	card, err := c.linkCards.fetch(ctx, uri)
+	if err != nil {
+		log.Printf("Warn: posting without link card: %v", err)
+		return nil
+	}
+
+	external := &bsky.EmbedExternal_External{
+		Uri:         uri,
+		Title:       card.title,
+		Description: card.description,
+	}
+	if card.thumb != nil {
+		var out atproto.RepoUploadBlob_Output
+		if err := c.xrpcClient.LexDo(ctx, lexutil.Procedure, card.thumbType, "com.atproto.repo.uploadBlob",
+			nil, bytes.NewReader(card.thumb), &out); err != nil {
+			log.Printf("Warn: posting link card of %s without thumbnail: uploading blob: %v", uri, err)
+		} else {
+			external.Thumb = out.Blob
+		}
+	}
+	return &bsky.FeedPost_Embed{EmbedExternal: &bsky.EmbedExternal{External: external}}
 }
 
-func (c *blueskyClient) PlatformName() string {
-	return "bluesky"
+// firstLink returns the first link in the posts.
+func firstLink(posts []string) string {
+	for _, p := range posts {
+		for _, tok := range scanFacets(p, "") {
+			if tok.kind == facetLink {
+				return tok.value
+			}
+		}
+	}
+	return ""
+}
+
+// resolveHandle resolves the handle of a mention to the DID of the account.
+func (c *blueskyClient) resolveHandle(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	ident, err := c.directory.LookupHandle(ctx, handle)
+	if err != nil {
+		return "", err
+	}
+	return ident.DID, nil
+}
+
+// replyRefs returns the references to the post with the given URI and to the
+// root of its thread.
+func (c *blueskyClient) replyRefs(ctx context.Context, uri string) (parent, root *atproto.RepoStrongRef, err error) {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", aturi.Collection().String(), aturi.Authority().String(), aturi.RecordKey().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if out.Cid == nil {
+		return nil, nil, fmt.Errorf("record %s has no CID", uri)
+	}
+	rec, ok := out.Value.Val.(*bsky.FeedPost)
+	if !ok {
+		return nil, nil, fmt.Errorf("record %s is not a post", uri)
+	}
+	parent = &atproto.RepoStrongRef{Uri: out.Uri, Cid: *out.Cid}
+	if rec.Reply != nil && rec.Reply.Root != nil {
+		return parent, rec.Reply.Root, nil
+	}
+	return parent, parent, nil
 }
 
-func (c *blueskyClient) MaxPosts() int {
-	return c.maxPosts
+func (c *blueskyClient) DeletePost(ctx context.Context, uri string) error {
+	if c.dryRun {
+		return nil
+	}
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return err
+	}
+	// Deleting a record that doesn't exist succeeds.
+	if _, err := atproto.RepoDeleteRecord(ctx, c.xrpcClient, &atproto.RepoDeleteRecord_Input{
+		Repo:       c.did,
+		Collection: aturi.Collection().String(),
+		Rkey:       aturi.RecordKey().String(),
+	}); err != nil {
+		return fmt.Errorf("deleting record: %w", err)
+	}
+	forgetCachedPost[*blueskyPost](c.postCachePath, uri)
+	return nil
+}
+
+func (c *blueskyClient) PlatformName() string {
+	return "bluesky"
 }
 
 func (c *blueskyClient) MaxPostLen() int {
 	return 300
 }
 
+func (c *blueskyClient) PostLen(text string) int {
+	return graphemeLen(text)
+}
+
+func (c *blueskyClient) RenderMessage(message string) string {
+	return renderLines(message, false)
+}
+
+func (c *blueskyClient) VerifyAccount(ctx context.Context) (string, error) {
+	session, err := atproto.ServerGetSession(ctx, c.xrpcClient)
+	if err != nil {
+		return "", fmt.Errorf("getting session: %w", err)
+	}
+	return "@" + session.Handle, nil
+}
+
 type blueskyPost struct {
 	*bsky.FeedPost
+	uri string
+	cid string
+}
+
+func (p *blueskyPost) ID() string {
+	if p == nil {
+		return ""
+	}
+	return p.uri
+}
+
+func (p *blueskyPost) InReplyTo() string {
+	if p == nil || p.FeedPost == nil || p.Reply == nil || p.Reply.Parent == nil {
+		return ""
+	}
+	return p.Reply.Parent.Uri
 }
 
 func (p *blueskyPost) Text() string {
@@ -175,66 +426,37 @@ func (p *blueskyPost) Text() string {
 	return p.FeedPost.Text
 }
 
-func hashtagFacetsFromString(s string) []*bsky.RichtextFacet {
-	newFacet := func(s string, start, end int) *bsky.RichtextFacet {
-		return &bsky.RichtextFacet{
-			Index: &bsky.RichtextFacet_ByteSlice{
-				ByteStart: int64(start),
-				ByteEnd:   int64(end),
-			},
-			Features: []*bsky.RichtextFacet_Features_Elem{
-				{
-					RichtextFacet_Tag: &bsky.RichtextFacet_Tag{
-						Tag: s[start+1 : end],
-					},
-				},
-			},
-		}
+// postedAt is the creation time of the record, as it was set by the client.
+func (p *blueskyPost) postedAt() time.Time {
+	if p == nil || p.FeedPost == nil {
+		return time.Time{}
 	}
-
-	var facets []*bsky.RichtextFacet
-	start := -1
-	for i, r := range s {
-		switch {
-		case r == '#':
-			start = i
-		case unicode.IsSpace(r):
-			if start < 0 {
-				continue
-			}
-			if i-start <= 1 { // At least one character after #
-				start = -1
-				continue
-			}
-			facets = append(facets, newFacet(s, start, i))
-		case i == len(s)-1 && start != -1: // End of string
-			facets = append(facets, newFacet(s, start, i+1))
-		}
+	t, err := syntax.ParseDatetimeLenient(p.CreatedAt)
+	if err != nil {
+		return time.Time{}
 	}
-	return facets
+	return t.Time()
 }
 
-func linkFacetsFromString(s string) []*bsky.RichtextFacet {
-	facets := []*bsky.RichtextFacet{}
+// MarshalJSON adds the URI and CID to the record, so that they are part of
+// the posts file and the post cache.
+func (p *blueskyPost) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*bsky.FeedPost
+		URI string `json:"uri,omitempty"`
+		CID string `json:"cid,omitempty"`
+	}{p.FeedPost, p.uri, p.cid})
+}
 
-	for _, w := range strings.Fields(s) {
-		if !strings.HasPrefix(w, "https://") {
-			continue
-		}
-		facets = append(facets, &bsky.RichtextFacet{
-			Index: &bsky.RichtextFacet_ByteSlice{
-				ByteStart: int64(strings.Index(s, w)),
-				ByteEnd:   int64(strings.Index(s, w) + len(w)),
-			},
-			Features: []*bsky.RichtextFacet_Features_Elem{
-				{
-					RichtextFacet_Link: &bsky.RichtextFacet_Link{
-						Uri: w,
-					},
-				},
-			},
-		})
+func (p *blueskyPost) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*bsky.FeedPost
+		URI string `json:"uri"`
+		CID string `json:"cid"`
+	}{FeedPost: &bsky.FeedPost{}}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
 	}
-
-	return facets
+	p.FeedPost, p.uri, p.cid = aux.FeedPost, aux.URI, aux.CID
+	return nil
 }
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,18 +29,21 @@ func TestBluesky(t *testing.T) {
 	require.NoError(json.Unmarshal(credsFile, &creds), "unmarshalling credentials")
 
 	client, err := newBlueskyClient(ctx, blueskyClientConfig{
-		handle:   creds["bluesky"]["handle"],
-		appkey:   creds["bluesky"]["appkey"],
-		dryRun:   false,
-		maxPosts: 100,
+		handle: creds["bluesky"]["handle"],
+		appkey: creds["bluesky"]["appkey"],
+		clientOptions: clientOptions{
+			name:     "bluesky",
+			dryRun:   false,
+			maxPosts: 100,
+		},
 	})
 	require.NoError(err, "creating Bluesky client")
 
-	err = client.CreatePostChain(ctx, []string{
+	_, err = client.CreatePostChain(ctx, []string{
 		"Hello, Bluesky! This is a test post.",
 		"This is the second part of the post chain.",
 		"And this is the third part of the post chain.",
-	})
+	}, "")
 	require.NoError(err, "creating post")
 
 	feed, err := client.ListPosts(ctx)
@@ -51,3 +59,144 @@ func PrintPosts(posts []post) {
 		fmt.Println("---")
 	}
 }
+
+// identityServer serves the well-known DID of the handle example.com, the
+// DID document of did:web:example.com and the PLC directory.
+func identityServer(t *testing.T, pds string) *identity.BaseDirectory {
+	didDoc := func(did string) map[string]any {
+		return map[string]any{
+			"id":          did,
+			"alsoKnownAs": []string{"at://example.com"},
+			"service": []map[string]string{{
+				"id":              "#atproto_pds",
+				"type":            "AtprotoPersonalDataServer",
+				"serviceEndpoint": pds,
+			}},
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/atproto-did", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.URL.Query().Get("did"))
+	})
+	mux.HandleFunc("/.well-known/did.json", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(didDoc("did:web:example.com"))
+	})
+	mux.HandleFunc("/did:plc:abc", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(didDoc("did:plc:abc"))
+	})
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+
+	// All hosts are served by the test server, its certificate is valid for
+	// example.com.
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	return &identity.BaseDirectory{
+		PLCURL:                server.URL,
+		HTTPClient:            http.Client{Transport: transport},
+		SkipDNSDomainSuffixes: []string{"example.com"},
+	}
+}
+
+func TestNewBlueskyClientResolvesPDS(t *testing.T) {
+	pds := httptest.NewServer(&fakePDS{})
+	defer pds.Close()
+	entryway := httptest.NewServer(&fakePDS{})
+	defer entryway.Close()
+
+	testCases := map[string]struct {
+		did      string
+		entryway string
+		wantHost string
+	}{
+		"did:web":  {did: "did:web:example.com", wantHost: pds.URL},
+		"did:plc":  {did: "did:plc:abc", wantHost: pds.URL},
+		"entryway": {did: "did:plc:abc", entryway: entryway.URL + "/", wantHost: entryway.URL},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir := identityServer(t, pds.URL)
+			dir.HTTPClient.Transport = &wellKnownDIDTransport{next: dir.HTTPClient.Transport, did: tc.did}
+
+			client, err := newBlueskyClient(context.Background(), blueskyClientConfig{
+				handle:    "@example.com",
+				appkey:    "password",
+				entryway:  tc.entryway,
+				directory: dir,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.did, client.did)
+			assert.Equal(t, tc.wantHost, client.xrpcClient.client.Host)
+		})
+	}
+
+	t.Run("unknown handle", func(t *testing.T) {
+		dir := identityServer(t, pds.URL)
+		_, err := newBlueskyClient(context.Background(), blueskyClientConfig{
+			handle:    "example.com",
+			directory: dir,
+		})
+		assert.Error(t, err)
+	})
+}
+
+// wellKnownDIDTransport selects the well-known DID served by the identity
+// server with a query parameter.
+type wellKnownDIDTransport struct {
+	next http.RoundTripper
+	did  string
+}
+
+func (t *wellKnownDIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set("did", t.did)
+	req.URL.RawQuery = q.Encode()
+	return t.next.RoundTrip(req)
+}
+
+func TestAuthorFeedPosts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	feedItem := func(uri, author, record, reason string) string {
+		item := fmt.Sprintf(`{"post": {"uri": %q, "cid": "cid-%s", "author": {"did": %q}, "record": %s}`, uri, uri, author, record)
+		if reason != "" {
+			item += fmt.Sprintf(`, "reason": {"$type": %q}`, reason)
+		}
+		return item + "}"
+	}
+	postRecord := func(text string) string {
+		return fmt.Sprintf(`{"$type": "app.bsky.feed.post", "text": %q, "createdAt": "2025-05-15T21:37:58Z"}`, text)
+	}
+	reply := `{"$type": "app.bsky.feed.post", "text": "second", "createdAt": "2025-05-15T21:37:58Z",
+		"reply": {"root": {"uri": "own", "cid": "cid-own"}, "parent": {"uri": "own", "cid": "cid-own"}}}`
+
+	data := fmt.Sprintf(`{"cursor": "next", "feed": [%s, %s, %s, %s, %s, %s, %s]}`,
+		feedItem("own", "did:plc:bot", postRecord("first"), ""),
+		feedItem("reply", "did:plc:bot", reply, ""),
+		feedItem("repost", "did:plc:other", postRecord("community post"), "app.bsky.feed.defs#reasonRepost"),
+		feedItem("pinned", "did:plc:bot", postRecord("pinned"), "app.bsky.feed.defs#reasonPin"),
+		feedItem("other", "did:plc:other", postRecord("someone else"), ""),
+		feedItem("unknown", "did:plc:bot", `{"$type": "app.example.record", "text": "?"}`, ""),
+		feedItem("malformed", "did:plc:bot", `{"$type": "app.bsky.feed.post", "text": 42}`, ""),
+	)
+	var feed authorFeed
+	require.NoError(json.Unmarshal([]byte(data), &feed))
+	assert.Equal("next", feed.Cursor)
+
+	posts := feed.posts("did:plc:bot")
+	require.Len(posts, 2)
+	assert.Equal("own", posts[0].ID())
+	assert.Equal("first", posts[0].Text())
+	assert.Equal("reply", posts[1].ID())
+	assert.Equal("own", posts[1].InReplyTo())
+
+	dump, err := json.Marshal(posts[1])
+	require.NoError(err)
+	assert.Contains(string(dump), `"uri":"reply"`)
+	assert.Contains(string(dump), `"cid":"cid-reply"`)
+	assert.Contains(string(dump), `"text":"second"`)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// blueskySession is an XRPC client that keeps its session alive. When the
+// access token expired, it is refreshed with the refresh token, and a new
+// session is created if that fails too. If cachePath is set, the session is
+// kept there between runs.
+type blueskySession struct {
+	client     *xrpc.Client
+	identifier string
+	password   string
+	cachePath  string
+
+	mu sync.Mutex
+}
+
+func newBlueskySession(ctx context.Context, client *xrpc.Client, identifier, password, cachePath string) (*blueskySession, error) {
+	s := &blueskySession{
+		client:     client,
+		identifier: strings.TrimPrefix(identifier, "@"),
+		password:   password,
+		cachePath:  cachePath,
+	}
+	if auth, ok := s.readCache(); ok {
+		s.client.Auth = auth
+		return s, nil
+	}
+	if err := s.create(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LexDo implements lexutil.LexClient.
+func (s *blueskySession) LexDo(ctx context.Context, method, inputEncoding, endpoint string, params map[string]any, bodyData, out any) error {
+	s.mu.Lock()
+	accessJwt := s.client.Auth.AccessJwt
+	s.mu.Unlock()
+
+	err := s.client.LexDo(ctx, method, inputEncoding, endpoint, params, bodyData, out)
+	if !isSessionError(err) {
+		return err
+	}
+	if r, ok := bodyData.(io.Reader); ok {
+		seeker, ok := r.(io.Seeker)
+		if !ok {
+			return err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewinding request body: %w", err)
+		}
+	}
+	if err := s.refresh(ctx, accessJwt); err != nil {
+		return err
+	}
+	return s.client.LexDo(ctx, method, inputEncoding, endpoint, params, bodyData, out)
+}
+
+// refresh replaces the session if it still uses the expired access token.
+func (s *blueskySession) refresh(ctx context.Context, expiredJwt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client.Auth.AccessJwt != expiredJwt {
+		// Refreshed in the meantime.
+		return nil
+	}
+
+	refreshClient := &xrpc.Client{
+		Client: s.client.Client,
+		Host:   s.client.Host,
+		Auth:   &xrpc.AuthInfo{AccessJwt: s.client.Auth.RefreshJwt},
+	}
+	out, err := atproto.ServerRefreshSession(ctx, refreshClient)
+	if err != nil {
+		log.Printf("Warn: refreshing Bluesky session failed, creating a new one: %v", err)
+		return s.createLocked(ctx)
+	}
+	log.Println("Refreshed Bluesky session")
+	s.setAuthLocked(&xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+	return nil
+}
+
+func (s *blueskySession) create(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createLocked(ctx)
+}
+
+func (s *blueskySession) createLocked(ctx context.Context) error {
+	// The session is created without the old, possibly invalid, tokens.
+	createClient := &xrpc.Client{Client: s.client.Client, Host: s.client.Host}
+	out, err := atproto.ServerCreateSession(ctx, createClient, &atproto.ServerCreateSession_Input{
+		Identifier: s.identifier,
+		Password:   s.password,
+	})
+	if err != nil {
+		return fmt.Errorf("creating authenticated session: %w", err)
+	}
+	s.setAuthLocked(&xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+	return nil
+}
+
+func (s *blueskySession) setAuthLocked(auth *xrpc.AuthInfo) {
+	s.client.Auth = auth
+	s.writeCache(auth)
+}
+
+// readCache returns the cached session if it is for the configured account.
+func (s *blueskySession) readCache() (*xrpc.AuthInfo, bool) {
+	if s.cachePath == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(s.cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false
+	} else if err != nil {
+		log.Printf("Warn: reading Bluesky session cache: %v", err)
+		return nil, false
+	}
+	var auth xrpc.AuthInfo
+	if err := json.Unmarshal(data, &auth); err != nil {
+		log.Printf("Warn: parsing Bluesky session cache %q: %v", s.cachePath, err)
+		return nil, false
+	}
+	if auth.AccessJwt == "" || (auth.Handle != s.identifier && auth.Did != s.identifier) {
+		return nil, false
+	}
+	log.Printf("Using cached Bluesky session from %s", s.cachePath)
+	return &auth, true
+}
+
+// writeCache stores the session. Failures only disable caching, so they are
+// logged and otherwise ignored.
+func (s *blueskySession) writeCache(auth *xrpc.AuthInfo) {
+	if s.cachePath == "" {
+		return
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		log.Printf("Warn: marshaling Bluesky session: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cachePath), 0o700); err != nil {
+		log.Printf("Warn: creating Bluesky session cache directory: %v", err)
+		return
+	}
+	// The session grants access to the account, like the app password.
+	if err := os.WriteFile(s.cachePath, data, 0o600); err != nil {
+		log.Printf("Warn: writing Bluesky session cache: %v", err)
+	}
+}
+
+// isSessionError reports whether err means that the access token expired or
+// is not valid anymore.
+func isSessionError(err error) bool {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return false
+	}
+	var xe *xrpc.XRPCError
+	if errors.As(xrpcErr.Wrapped, &xe) && (xe.ErrStr == "ExpiredToken" || xe.ErrStr == "InvalidToken") {
+		return true
+	}
+	return xrpcErr.StatusCode == http.StatusUnauthorized
+}
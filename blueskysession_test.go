@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePDS issues numbered tokens. Only the latest tokens are valid.
+type fakePDS struct {
+	sessions  int
+	refreshes int
+	access    string
+	refresh   string
+	// refreshFails makes refreshSession reject every token.
+	refreshFails bool
+}
+
+func (p *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.createSession":
+		p.sessions++
+		p.issue(w)
+	case "/xrpc/com.atproto.server.refreshSession":
+		if p.refreshFails || auth != "Bearer "+p.refresh {
+			xrpcError(w, "ExpiredToken")
+			return
+		}
+		p.refreshes++
+		p.issue(w)
+	case "/xrpc/com.atproto.server.getSession":
+		if auth != "Bearer "+p.access {
+			xrpcError(w, "ExpiredToken")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"handle": "test.bsky.social", "did": "did:plc:test"})
+	case "/xrpc/com.atproto.repo.uploadBlob":
+		if auth != "Bearer "+p.access {
+			xrpcError(w, "ExpiredToken")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]any{"blob": map[string]any{
+			"$type":    "blob",
+			"ref":      map[string]string{"$link": "bafkreibme22gw2h7y2h7tg2fhqotaqjucnbc24deqo72b6mkl2egezxhvy"},
+			"mimeType": r.Header.Get("Content-Type"),
+			"size":     len(body),
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *fakePDS) issue(w http.ResponseWriter) {
+	n := p.sessions + p.refreshes
+	p.access, p.refresh = fmt.Sprintf("access%d", n), fmt.Sprintf("refresh%d", n)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"accessJwt":  p.access,
+		"refreshJwt": p.refresh,
+		"handle":     "test.bsky.social",
+		"did":        "did:plc:test",
+	})
+}
+
+// expire invalidates the access token, but not the refresh token.
+func (p *fakePDS) expire() {
+	p.access = "expired"
+}
+
+func xrpcError(w http.ResponseWriter, errStr string) {
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": errStr, "message": "Token has expired"})
+}
+
+func TestBlueskySession(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+
+	pds := &fakePDS{}
+	server := httptest.NewServer(pds)
+	defer server.Close()
+	cachePath := filepath.Join(t.TempDir(), "session.json")
+	newClient := func() *xrpc.Client {
+		return &xrpc.Client{Client: server.Client(), Host: server.URL}
+	}
+
+	session, err := newBlueskySession(ctx, newClient(), "@test.bsky.social", "password", cachePath)
+	require.NoError(err)
+	assert.Equal(1, pds.sessions)
+	assert.Equal("did:plc:test", session.client.Auth.Did)
+	info, err := os.Stat(cachePath)
+	require.NoError(err)
+	assert.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = atproto.ServerGetSession(ctx, session)
+	require.NoError(err)
+
+	pds.expire()
+	_, err = atproto.ServerGetSession(ctx, session)
+	require.NoError(err, "expired access token should be refreshed")
+	assert.Equal(1, pds.refreshes)
+	assert.Equal(1, pds.sessions)
+
+	cached, err := newBlueskySession(ctx, newClient(), "test.bsky.social", "password", cachePath)
+	require.NoError(err)
+	_, err = atproto.ServerGetSession(ctx, cached)
+	require.NoError(err, "cached session should be valid")
+	assert.Equal(1, pds.sessions, "cached session should be used")
+
+	pds.expire()
+	pds.refreshFails = true
+	_, err = atproto.ServerGetSession(ctx, cached)
+	require.NoError(err, "a new session should be created if refreshing fails")
+	assert.Equal(2, pds.sessions)
+
+	_, err = newBlueskySession(ctx, newClient(), "other.bsky.social", "password", cachePath)
+	require.NoError(err)
+	assert.Equal(3, pds.sessions, "cached session of another account shouldn't be used")
+}
+
+func TestBlueskySessionWithoutCache(t *testing.T) {
+	pds := &fakePDS{}
+	server := httptest.NewServer(pds)
+	defer server.Close()
+
+	for range 2 {
+		_, err := newBlueskySession(context.Background(), &xrpc.Client{Client: server.Client(), Host: server.URL}, "test.bsky.social", "password", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, pds.sessions)
+}
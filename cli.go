@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = ""
+
+type command struct {
+	name        string
+	description string
+	// configFlags adds the flags overriding the HMNB_* variables.
+	configFlags bool
+	run         func(ctx context.Context, env *cliEnv) error
+}
+
+// cliEnv is what a command runs with.
+type cliEnv struct {
+	stdout io.Writer
+	// lookupEnv returns the HMNB_* variables with the flags applied.
+	lookupEnv func(string) (string, bool)
+	// accounts the command is restricted to, all if empty.
+	accounts []string
+}
+
+var commands = []command{
+	{name: "post", description: "post unposted news entries (default)", configFlags: true, run: postCommand},
+	{name: "preview", description: "show what would be posted per account", configFlags: true, run: previewCommand},
+	{name: "list", description: "show the posts of each account and their news entry", configFlags: true, run: listCommand},
+	{name: "doctor", description: "check config, news source, credentials and connectivity", configFlags: true, run: doctorCommand},
+	{name: "version", description: "print the version", run: versionCommand},
+}
+
+// envFlags are the flags that take precedence over HMNB_* variables.
+var envFlags = []struct {
+	name, env, usage string
+	isBool           bool
+}{
+	{name: "config", env: "HMNB_CONFIG", usage: "path of the config file"},
+	{name: "source", env: "HMNB_SOURCE", usage: "news source (file:PATH, cmd:COMMAND, hm:PATH, URL or -)"},
+	{name: "ledger", env: "HMNB_LEDGER_PATH", usage: "path of the ledger file"},
+	{name: "cache-dir", env: "HMNB_CACHE_DIR", usage: "cache directory"},
+	{name: "max-posts", env: "HMNB_MAX_POSTS", usage: "maximum number of news entries posted per account"},
+	{name: "dry-run", env: "HMNB_DRY_RUN", usage: "don't post anything", isBool: true},
+}
+
+// envFlag stores its value as the environment variable it overrides.
+type envFlag struct {
+	env    string
+	values map[string]string
+	isBool bool
+}
+
+func (f *envFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return f.values[f.env]
+}
+
+func (f *envFlag) Set(v string) error {
+	f.values[f.env] = v
+	return nil
+}
+
+func (f *envFlag) IsBoolFlag() bool { return f.isBool }
+
+func runCLI(ctx context.Context, args []string, stdout io.Writer, lookupEnv func(string) (string, bool)) error {
+	// Without a command, the bot posts, like it did before it had commands.
+	name := "post"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		return printUsage(stdout)
+	}
+	idx := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if idx < 0 {
+		return fmt.Errorf("unknown command %q, run 'hmnews-bot help' for usage", name)
+	}
+	cmd := commands[idx]
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flagValues := make(map[string]string)
+	var accounts string
+	if cmd.configFlags {
+		for _, f := range envFlags {
+			fs.Var(&envFlag{env: f.env, values: flagValues, isBool: f.isBool}, f.name, f.usage+", overrides "+f.env)
+		}
+		fs.StringVar(&accounts, "account", "", "comma-separated accounts to run for, all if empty")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments for %s: %s", cmd.name, strings.Join(fs.Args(), " "))
+	}
+
+	env := &cliEnv{
+		stdout: stdout,
+		lookupEnv: func(key string) (string, bool) {
+			if v, ok := flagValues[key]; ok {
+				return v, true
+			}
+			return lookupEnv(key)
+		},
+	}
+	if accounts != "" {
+		env.accounts = strings.Split(accounts, ",")
+	}
+	return cmd.run(ctx, env)
+}
+
+func printUsage(w io.Writer) error {
+	var out, table bytes.Buffer
+	fmt.Fprintln(&out, "Usage: hmnews-bot [command] [flags]")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(&table, "  %s\t%s\n", c.name, c.description)
+	}
+	if err := writeTable(&out, &table); err != nil {
+		return err
+	}
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "Run 'hmnews-bot <command> -h' for the flags of a command.")
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// writeTable aligns the tab-separated columns of table.
+func writeTable(out *bytes.Buffer, table *bytes.Buffer) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if _, err := table.WriteTo(tw); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// loadConfig loads the config and restricts it to the selected accounts.
+func (e *cliEnv) loadConfig() (*config, error) {
+	path, _ := e.lookupEnv("HMNB_CONFIG")
+	cfg, err := loadConfig(path, e.lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.accounts) == 0 {
+		return cfg, nil
+	}
+	var selected []accountConfig
+	for _, name := range e.accounts {
+		acc := cfg.account(name)
+		if acc == nil {
+			return nil, fmt.Errorf("account %q not configured", name)
+		}
+		selected = append(selected, *acc)
+	}
+	cfg.Accounts = selected
+	return cfg, nil
+}
+
+func readNews(ctx context.Context, cfg *config) ([]newsEntry, error) {
+	source, err := parseNewsSource(cfg.Source, newsSourceConfig{
+		cacheDir:          cfg.CacheDir,
+		homeManagerSystem: cfg.HomeManagerSystem,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parsing news source: %w", err)
+	}
+	log.Printf("Reading news from %s", source)
+	news, err := source.News(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading news: %w", err)
+	}
+	if cfg.OptionsJSON != "" {
+		doc, err := readOptionsDoc(cfg.OptionsJSON)
+		if err != nil {
+			return nil, err
+		}
+		news = attachModuleDocs(news, doc)
+	}
+	return news, nil
+}
+
+func openLedger(cfg *config) (ledger, error) {
+	if cfg.LedgerPath == "" {
+		log.Println("HMNB_LEDGER_PATH not set, posted entries won't be recorded")
+		return newMemLedger(), nil
+	}
+	postLedger, err := newFileLedger(cfg.LedgerPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening ledger: %w", err)
+	}
+	return postLedger, nil
+}
+
+// setup loads everything the post, preview and list commands need.
+func (e *cliEnv) setup(ctx context.Context) ([]newsEntry, []postingClient, ledger, error) {
+	cfg, err := e.loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	postLedger, err := openLedger(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	news, err := readNews(ctx, cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clients, err := enabledClients(ctx, cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(clients) == 0 {
+		return nil, nil, nil, errors.New("no platform configured")
+	}
+	return news, clients, postLedger, nil
+}
+
+func postCommand(ctx context.Context, env *cliEnv) error {
+	news, clients, postLedger, err := env.setup(ctx)
+	if err != nil {
+		return err
+	}
+	return run(ctx, news, clients, postLedger)
+}
+
+func previewCommand(ctx context.Context, env *cliEnv) error {
+	news, clients, postLedger, err := env.setup(ctx)
+	if err != nil {
+		return err
+	}
+	return preview(ctx, env.stdout, news, clients, postLedger)
+}
+
+// preview prints the threads the post command would create.
+func preview(ctx context.Context, w io.Writer, news []newsEntry, clients []postingClient, postLedger ledger) error {
+	var out bytes.Buffer
+	news = prepareNews(news)
+	for _, c := range clients {
+		posts, err := c.ListPosts(ctx)
+		if err != nil {
+			return fmt.Errorf("listing %s posts: %w", c.Name(), err)
+		}
+		threads, _ := unpostedThreads(c, news, posts, postLedger)
+		next := threads[:min(len(threads), c.MaxPosts())]
+
+		fmt.Fprintf(&out, "=== %s (%s): posting %d of %d unposted news entries", c.Name(), c.PlatformName(), len(next), len(threads))
+		if c.DryRun() {
+			fmt.Fprint(&out, ", dry run")
+		}
+		fmt.Fprintln(&out)
+		for _, thread := range next {
+			fmt.Fprintf(&out, "\n--- %s %s", thread.entry.Time.Format("2006-01-02"), thread.entry.ID)
+			if thread.edit != nil {
+				fmt.Fprintf(&out, " (edited, %s)", c.EditStrategy())
+			}
+			if len(thread.postedIDs) > 0 {
+				fmt.Fprintf(&out, " (resuming after %d parts)", len(thread.postedIDs))
+			}
+			fmt.Fprintln(&out)
+			// Only the remaining parts of partially posted threads are posted.
+			for i, p := range thread.remaining() {
+				fmt.Fprintf(&out, "%d/%d (%d characters):\n%s\n", len(thread.postedIDs)+i+1, len(thread.posts), c.PostLen(p), p)
+			}
+			if thread.edit == nil && thread.entry.ModuleDoc != "" {
+				docPosts := clientPosts(c, thread.entry.ModuleDoc, "")
+				for i, p := range docPosts {
+					fmt.Fprintf(&out, "reply %d/%d (%d characters):\n%s\n", i+1, len(docPosts), c.PostLen(p), p)
+				}
+			}
+		}
+		fmt.Fprintln(&out)
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func listCommand(ctx context.Context, env *cliEnv) error {
+	news, clients, _, err := env.setup(ctx)
+	if err != nil {
+		return err
+	}
+	return list(ctx, env.stdout, news, clients)
+}
+
+// list prints the posts of each client with the news entry they belong to.
+func list(ctx context.Context, w io.Writer, news []newsEntry, clients []postingClient) error {
+	var out bytes.Buffer
+	news = prepareNews(news)
+	for _, c := range clients {
+		posts, err := c.ListPosts(ctx)
+		if err != nil {
+			return fmt.Errorf("listing %s posts: %w", c.Name(), err)
+		}
+		fmt.Fprintf(&out, "=== %s (%s): %d posts\n", c.Name(), c.PlatformName(), len(posts))
+
+		// Each post belongs to the first entry whose first part it contains.
+		index := newPostIndex(posts)
+		entries := make([]int, len(posts))
+		for i := range entries {
+			entries[i] = -1
+		}
+		for i, n := range news {
+			parts := clientPosts(c, n.Message, c.HashTags())
+			if len(parts) == 0 {
+				continue
+			}
+			for _, j := range index.matches(canonicalizePost(parts[0]), 0) {
+				if entries[j] < 0 {
+					entries[j] = i
+				}
+			}
+		}
+
+		var table bytes.Buffer
+		fmt.Fprintln(&table, "ENTRY\tTIME\tPOST")
+		for j, p := range index.posts {
+			entryID, entryTime := "-", "-"
+			if i := entries[j]; i >= 0 {
+				entryID, entryTime = shortID(news[i].ID), news[i].Time.Format("2006-01-02")
+			}
+			fmt.Fprintf(&table, "%s\t%s\t%s\n", entryID, entryTime, truncate(spaceRegexp.ReplaceAllString(p.canonical, " "), 60))
+		}
+		if err := writeTable(&out, &table); err != nil {
+			return err
+		}
+		fmt.Fprintln(&out)
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func shortID(id string) string {
+	if id == "" {
+		return "?"
+	}
+	return truncate(id, 12)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+var errDoctorFailed = errors.New("some checks failed")
+
+// doctorCommand runs all checks, also after one failed, and reports each of
+// them.
+func doctorCommand(ctx context.Context, env *cliEnv) error {
+	failed := false
+	check := func(name string, detail string, err error) bool {
+		if err != nil {
+			_, _ = fmt.Fprintf(env.stdout, "FAIL  %s: %v\n", name, err)
+			failed = true
+			return false
+		}
+		if detail != "" {
+			name += ": " + detail
+		}
+		_, _ = fmt.Fprintf(env.stdout, "ok    %s\n", name)
+		return true
+	}
+
+	cfg, err := env.loadConfig()
+	if !check("config", "", err) {
+		return errDoctorFailed
+	}
+
+	news, err := readNews(ctx, cfg)
+	check("news source", fmt.Sprintf("%d entries from %s", len(news), cfg.Source), err)
+
+	if cfg.LedgerPath == "" {
+		check("ledger", "not configured, posted entries won't be recorded", nil)
+	} else {
+		_, err := openLedger(cfg)
+		check("ledger", cfg.LedgerPath, err)
+	}
+
+	if len(cfg.Accounts) == 0 {
+		check("accounts", "", errors.New("no platform configured"))
+	}
+	for _, acc := range cfg.Accounts {
+		name := fmt.Sprintf("account %s (%s)", acc.Name, acc.Platform)
+		client, err := newAccountClient(ctx, cfg, acc)
+		if !check(name, "configured", err) {
+			continue
+		}
+		verifier, ok := client.(accountVerifier)
+		if !ok {
+			continue
+		}
+		user, err := verifier.VerifyAccount(ctx)
+		check(name, "logged in as "+user, err)
+	}
+
+	if failed {
+		return errDoctorFailed
+	}
+	return nil
+}
+
+func versionCommand(_ context.Context, env *cliEnv) error {
+	_, err := fmt.Fprintln(env.stdout, "hmnews-bot", versionString())
+	return err
+}
+
+func versionString() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		if version != "" {
+			return version
+		}
+		return "unknown"
+	}
+	v := version
+	if v == "" {
+		v = info.Main.Version
+	}
+	settings := make(map[string]string)
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if rev := settings["vcs.revision"]; rev != "" {
+		if settings["vcs.modified"] == "true" {
+			rev += "-dirty"
+		}
+		v += fmt.Sprintf(" (%s, %s)", rev, settings["vcs.time"])
+	}
+	return v + " " + info.GoVersion
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCLI(t *testing.T) {
+	newsPath := "testdata/2025-05-15T21:37:58/news.json"
+
+	testCases := map[string]struct {
+		args       []string
+		env        map[string]string
+		wantErr    bool
+		wantStdout []string
+	}{
+		"version": {
+			args:       []string{"version"},
+			wantStdout: []string{"hmnews-bot "},
+		},
+		"help": {
+			args:       []string{"help"},
+			wantStdout: []string{"Usage:", "preview", "doctor"},
+		},
+		"unknown command": {
+			args:    []string{"tweet"},
+			wantErr: true,
+		},
+		"unexpected argument": {
+			args:    []string{"doctor", "extra"},
+			wantErr: true,
+		},
+		"unknown flag": {
+			args:    []string{"preview", "-verbose"},
+			wantErr: true,
+		},
+		"flags override env": {
+			args: []string{"doctor", "-source", "file:" + newsPath, "-max-posts", "1"},
+			env: map[string]string{
+				"HMNB_SOURCE":    "file:does-not-exist.json",
+				"HMNB_MAX_POSTS": "not a number",
+			},
+			// No account is configured.
+			wantErr:    true,
+			wantStdout: []string{"ok    config", "ok    news source: 233 entries", "FAIL  accounts"},
+		},
+		"env without flags": {
+			args: []string{"doctor", "-max-posts=1"},
+			env:  map[string]string{"HMNB_SOURCE": "file:does-not-exist.json"},
+			// No account is configured.
+			wantErr:    true,
+			wantStdout: []string{"ok    config", "FAIL  news source"},
+		},
+		"unknown account": {
+			args:    []string{"preview", "-source", newsPath, "-max-posts", "1", "-account", "mastodon"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var stdout bytes.Buffer
+			err := runCLI(context.Background(), tc.args, &stdout, lookupMap(tc.env))
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			for _, want := range tc.wantStdout {
+				assert.Contains(stdout.String(), want)
+			}
+		})
+	}
+}
+
+func TestPreviewAndList(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+
+	f, err := os.ReadFile("testdata/2025-05-15T21:37:58/news.json")
+	require.NoError(err)
+	var newsFile newsFile
+	require.NoError(json.Unmarshal(f, &newsFile))
+	f, err = os.ReadFile("testdata/2025-05-15T21:37:58/mastodon.json")
+	require.NoError(err)
+	var mastodonPosts []*mastodon.Status
+	require.NoError(json.Unmarshal(f, &mastodonPosts))
+
+	now := time.Date(2025, 5, 15, 21, 37, 58, 0, time.UTC)
+	client := stubPostingClientFromMastodonPosts(mastodonPosts)
+	client.newsFilter = map[string]func(newsEntry) bool{
+		"not older than 90d": func(n newsEntry) bool {
+			return n.Time.After(now.AddDate(0, 0, -postWindow))
+		},
+	}
+
+	var stdout bytes.Buffer
+	require.NoError(preview(ctx, &stdout, copySlice(newsFile.Entries), []postingClient{client}, newMemLedger()))
+	assert.Contains(stdout.String(), "=== stub (stub): posting 2 of")
+	assert.Contains(stdout.String(), "programs.kickoff")
+	assert.Contains(stdout.String(), "programs.mpvpaper")
+	assert.Empty(client.createPostChainPosts, "preview must not post")
+
+	stdout.Reset()
+	require.NoError(list(ctx, &stdout, copySlice(newsFile.Entries), []postingClient{client}))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(lines, len(mastodonPosts)+2)
+	assert.Equal(fmt.Sprintf("=== stub (stub): %d posts", len(mastodonPosts)), lines[0])
+	var matched int
+	for _, line := range lines[2:] {
+		if !strings.HasPrefix(line, "- ") {
+			matched++
+		}
+	}
+	assert.Positive(matched, "posts should be matched to their news entry")
+}
+
+func TestPreviewResumedThread(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	postLedger := newMemLedger()
+	require.NoError(postLedger.Record("stub", ledgerRecord{EntryID: "three", PostIDs: []string{"0"}, Parts: 3}))
+	client := &stubPostingClient{maxPostLen: 100}
+
+	var stdout bytes.Buffer
+	require.NoError(preview(context.Background(), &stdout, []newsEntry{threeParts()}, []postingClient{client}, postLedger))
+	assert.Contains(stdout.String(), "--- 0001-01-01 three (resuming after 1 parts)")
+	assert.NotContains(stdout.String(), "1/3 (", "posted parts shouldn't be previewed")
+	assert.Contains(stdout.String(), "2/3 (")
+	assert.Contains(stdout.String(), "3/3 (")
+}
+
+func TestPreviewCountsCharactersOfClient(t *testing.T) {
+	client := &stubPostingClient{maxPostLen: 500}
+	entry := newsEntry{ID: "emoji", Condition: true, Message: "Flags like 🇩🇪 count as one character."}
+
+	var stdout bytes.Buffer
+	require.NoError(t, preview(context.Background(), &stdout, []newsEntry{entry}, []postingClient{client}, newMemLedger()))
+	posts := clientPosts(client, entry.Message, client.HashTags())
+	require.Len(t, posts, 1)
+	assert.Contains(t, stdout.String(), fmt.Sprintf("1/1 (%d characters)", graphemeLen(posts[0])))
+}
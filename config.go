@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the bot configuration. It is read from a YAML file, the HMNB_*
+// environment variables take precedence over the file.
+type config struct {
+	// Source of the news, see parseNewsSource.
+	Source            string `yaml:"source"`
+	LedgerPath        string `yaml:"ledgerPath"`
+	CacheDir          string `yaml:"cacheDir"`
+	HomeManagerSystem string `yaml:"homeManagerSystem"`
+	// OptionsSearch is the URL option paths in posts link to, with
+	// optionPlaceholder for the path, or "none".
+	OptionsSearch string `yaml:"optionsSearch"`
+	// OptionsJSON is the options.json of the Home Manager documentation.
+	// If set, announcements of new modules are followed by the
+	// documentation of the module.
+	OptionsJSON string `yaml:"optionsJson"`
+	// MaxPosts, DryRun, OnEdit and OnRemove are the defaults for all
+	// accounts.
+	MaxPosts *int            `yaml:"maxPosts"`
+	DryRun   bool            `yaml:"dryRun"`
+	OnEdit   editStrategy    `yaml:"onEdit"`
+	OnRemove removeStrategy  `yaml:"onRemove"`
+	Accounts []accountConfig `yaml:"accounts"`
+}
+
+type accountConfig struct {
+	// Name of the account, used for the ledger and the posts file.
+	// Defaults to the platform name.
+	Name     string `yaml:"name"`
+	Platform string `yaml:"platform"`
+	MaxPosts *int   `yaml:"maxPosts"`
+	DryRun   *bool  `yaml:"dryRun"`
+	// OnEdit is what is done when a posted news entry is edited. Defaults
+	// to the strategy of the platform.
+	OnEdit editStrategy `yaml:"onEdit"`
+	// OnRemove is what is done when a posted news entry is removed from the
+	// news. Defaults to report.
+	OnRemove removeStrategy `yaml:"onRemove"`
+	// HashTags are appended to the first post of a thread, without '#'.
+	// Defaults to the hashtags of the bot.
+	HashTags []string     `yaml:"hashtags"`
+	Filters  filterConfig `yaml:"filters"`
+	// Settings of the platform, like "server" or "access_token".
+	Settings map[string]string `yaml:"settings"`
+}
+
+type filterConfig struct {
+	// MaxAgeDays is the maximum age of entries that are posted.
+	MaxAgeDays *int `yaml:"maxAgeDays"`
+	// Entries are posted if their message matches all Include and none of
+	// the Exclude regular expressions.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+var accountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// loadConfig reads the config file at path, if any, and applies the
+// environment on top.
+func loadConfig(path string, lookupEnv func(string) (string, bool)) (*config, error) {
+	cfg := &config{}
+	if path != "" {
+		f, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(f))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %q: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := cfg.resolveSecrets(lookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	getenv := func(key string) string {
+		v, _ := lookupEnv(key)
+		return v
+	}
+
+	switch {
+	case getenv("HMNB_SOURCE") != "":
+		c.Source = getenv("HMNB_SOURCE")
+	case getenv("HMNB_HOME_MANAGER_PATH") != "":
+		c.Source = "hm:" + getenv("HMNB_HOME_MANAGER_PATH")
+	case getenv("HMNB_PATH") != "":
+		c.Source = "file:" + getenv("HMNB_PATH")
+	}
+	if v := getenv("HMNB_LEDGER_PATH"); v != "" {
+		c.LedgerPath = v
+	}
+	if v := getenv("HMNB_CACHE_DIR"); v != "" {
+		c.CacheDir = v
+	}
+	if v := getenv("HMNB_HOME_MANAGER_SYSTEM"); v != "" {
+		c.HomeManagerSystem = v
+	}
+	if v := getenv("HMNB_OPTIONS_SEARCH"); v != "" {
+		c.OptionsSearch = v
+	}
+	if v := getenv("HMNB_OPTIONS_JSON"); v != "" {
+		c.OptionsJSON = v
+	}
+
+	// Overrides of the account defaults apply to all accounts.
+	if v := getenv("HMNB_MAX_POSTS"); v != "" {
+		maxPosts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("parsing HMNB_MAX_POSTS: %w", err)
+		}
+		c.MaxPosts = &maxPosts
+		for i := range c.Accounts {
+			c.Accounts[i].MaxPosts = nil
+		}
+	}
+	if v := getenv("HMNB_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("parsing HMNB_DRY_RUN: %w", err)
+		}
+		c.DryRun = dryRun
+		for i := range c.Accounts {
+			c.Accounts[i].DryRun = nil
+		}
+	}
+
+	if v := getenv("HMNB_ON_EDIT"); v != "" {
+		c.OnEdit = editStrategy(v)
+		for i := range c.Accounts {
+			c.Accounts[i].OnEdit = ""
+		}
+	}
+
+	if v := getenv("HMNB_ON_REMOVE"); v != "" {
+		c.OnRemove = removeStrategy(v)
+		for i := range c.Accounts {
+			c.Accounts[i].OnRemove = ""
+		}
+	}
+
+	// Platform settings apply to the account named like the platform,
+	// which is created if the config file doesn't have it.
+	for _, p := range platforms {
+		for _, setting := range p.settings {
+			key, v := setting.name, getenv(p.envName(setting))
+			if v == "" && setting.secret {
+				key, v = setting.name+secretFileSuffix, getenv(p.envName(setting)+strings.ToUpper(secretFileSuffix))
+			}
+			if v == "" {
+				continue
+			}
+			acc := c.account(p.name)
+			if acc == nil {
+				c.Accounts = append(c.Accounts, accountConfig{Name: p.name, Platform: p.name})
+				acc = &c.Accounts[len(c.Accounts)-1]
+			}
+			if acc.Settings == nil {
+				acc.Settings = make(map[string]string)
+			}
+			// A secret from the environment replaces the one from the file,
+			// regardless of how either is given.
+			delete(acc.Settings, setting.name)
+			delete(acc.Settings, setting.name+secretFileSuffix)
+			acc.Settings[key] = v
+		}
+	}
+	return nil
+}
+
+// resolveSecrets reads the secrets given as files or systemd credentials.
+func (c *config) resolveSecrets(lookupEnv func(string) (string, bool)) error {
+	for i := range c.Accounts {
+		acc := &c.Accounts[i]
+		p, ok := platformByName(acc.Platform)
+		if !ok {
+			continue
+		}
+		for _, setting := range p.settings {
+			if !setting.secret {
+				continue
+			}
+			fileKey := setting.name + secretFileSuffix
+			path, fromFile := acc.Settings[fileKey]
+			if fromFile {
+				delete(acc.Settings, fileKey)
+			}
+			if acc.Settings[setting.name] != "" {
+				continue
+			}
+
+			var secret string
+			if fromFile {
+				var err error
+				if secret, err = readSecretFile(path); err != nil {
+					return fmt.Errorf("account %q: %s: %w", acc.Name, fileKey, err)
+				}
+			} else {
+				credential, ok, err := readCredential(lookupEnv, acc.Name+"."+setting.name)
+				if err != nil {
+					return fmt.Errorf("account %q: %w", acc.Name, err)
+				}
+				if !ok {
+					continue
+				}
+				secret = credential
+			}
+			if acc.Settings == nil {
+				acc.Settings = make(map[string]string)
+			}
+			acc.Settings[setting.name] = secret
+		}
+	}
+	return nil
+}
+
+func (c *config) setDefaults() {
+	if c.HomeManagerSystem == "" {
+		c.HomeManagerSystem = "x86_64-linux"
+	}
+	if c.OptionsSearch == "" {
+		c.OptionsSearch = defaultOptionsSearch
+	}
+	if c.CacheDir == "" {
+		if userCacheDir, err := os.UserCacheDir(); err == nil {
+			c.CacheDir = filepath.Join(userCacheDir, "hmnews-bot")
+		}
+	}
+	for i := range c.Accounts {
+		if c.Accounts[i].Name == "" {
+			c.Accounts[i].Name = c.Accounts[i].Platform
+		}
+	}
+}
+
+func (c *config) validate() error {
+	var errs []error
+	if c.Source == "" {
+		errs = append(errs, errors.New("no news source, set HMNB_SOURCE"))
+	}
+	if c.OptionsSearch != "none" {
+		if u, err := url.Parse(c.OptionsSearch); err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			!strings.Contains(c.OptionsSearch, optionPlaceholder) {
+			errs = append(errs, fmt.Errorf("invalid optionsSearch %q, must be an HTTP(S) URL with %s or none", c.OptionsSearch, optionPlaceholder))
+		}
+	}
+	if c.OnEdit != "" && !c.OnEdit.valid() {
+		errs = append(errs, fmt.Errorf("invalid onEdit %q, must be edit, reply or none", c.OnEdit))
+	}
+	if c.OnRemove != "" && !c.OnRemove.valid() {
+		errs = append(errs, fmt.Errorf("invalid onRemove %q, must be delete, reply or report", c.OnRemove))
+	}
+	names := make(map[string]bool)
+	for _, acc := range c.Accounts {
+		if !accountNameRegexp.MatchString(acc.Name) {
+			errs = append(errs, fmt.Errorf("invalid account name %q", acc.Name))
+		}
+		if names[acc.Name] {
+			errs = append(errs, fmt.Errorf("duplicate account %q", acc.Name))
+		}
+		names[acc.Name] = true
+		if p, ok := platformByName(acc.Platform); !ok {
+			errs = append(errs, fmt.Errorf("account %q: unknown platform %q", acc.Name, acc.Platform))
+		} else {
+			for key := range acc.Settings {
+				if !slices.ContainsFunc(p.settings, func(s platformSetting) bool {
+					return s.name == key || (s.secret && s.name+secretFileSuffix == key)
+				}) {
+					errs = append(errs, fmt.Errorf("account %q: unknown setting %q", acc.Name, key))
+				}
+			}
+		}
+		if acc.OnEdit != "" && !acc.OnEdit.valid() {
+			errs = append(errs, fmt.Errorf("account %q: invalid onEdit %q, must be edit, reply or none", acc.Name, acc.OnEdit))
+		}
+		if acc.OnRemove != "" && !acc.OnRemove.valid() {
+			errs = append(errs, fmt.Errorf("account %q: invalid onRemove %q, must be delete, reply or report", acc.Name, acc.OnRemove))
+		}
+		if acc.MaxPosts == nil && c.MaxPosts == nil {
+			errs = append(errs, fmt.Errorf("account %q: maxPosts not set, set HMNB_MAX_POSTS", acc.Name))
+		}
+		for _, expr := range slices.Concat(acc.Filters.Include, acc.Filters.Exclude) {
+			if _, err := regexp.Compile(expr); err != nil {
+				errs = append(errs, fmt.Errorf("account %q: invalid filter: %w", acc.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *config) account(name string) *accountConfig {
+	for i := range c.Accounts {
+		if c.Accounts[i].Name == name || (c.Accounts[i].Name == "" && c.Accounts[i].Platform == name) {
+			return &c.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// clientOptions resolves the platform independent options of the account.
+func (c *config) clientOptions(acc accountConfig) clientOptions {
+	opts := clientOptions{
+		name:       acc.Name,
+		dryRun:     c.DryRun,
+		hashTags:   hashTags,
+		newsFilter: acc.Filters.newsFilter(time.Now()),
+		listWindow: time.Duration(acc.Filters.maxAgeDays())*24*time.Hour + listMargin,
+	}
+	if c.OptionsSearch != "none" {
+		opts.optionsSearch = c.OptionsSearch
+	}
+	if c.CacheDir != "" {
+		opts.postCachePath = filepath.Join(c.CacheDir, "posts-"+acc.Name+".json")
+	}
+	if c.MaxPosts != nil {
+		opts.maxPosts = *c.MaxPosts
+	}
+	if acc.MaxPosts != nil {
+		opts.maxPosts = *acc.MaxPosts
+	}
+	if acc.DryRun != nil {
+		opts.dryRun = *acc.DryRun
+	}
+	if acc.HashTags != nil {
+		opts.hashTags = formatHashTags(acc.HashTags)
+	}
+	switch {
+	case acc.OnEdit != "":
+		opts.onEdit = acc.OnEdit
+	case c.OnEdit != "":
+		opts.onEdit = c.OnEdit
+	default:
+		if p, ok := platformByName(acc.Platform); ok {
+			opts.onEdit = p.editStrategy
+		}
+	}
+	switch {
+	case acc.OnRemove != "":
+		opts.onRemove = acc.OnRemove
+	case c.OnRemove != "":
+		opts.onRemove = c.OnRemove
+	default:
+		opts.onRemove = removeStrategyReport
+	}
+	return opts
+}
+
+func formatHashTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	formatted := make([]string, len(tags))
+	for i, tag := range tags {
+		formatted[i] = "#" + strings.TrimPrefix(tag, "#")
+	}
+	return "\n" + strings.Join(formatted, " ")
+}
+
+func (f filterConfig) maxAgeDays() int {
+	if f.MaxAgeDays != nil {
+		return *f.MaxAgeDays
+	}
+	return postWindow
+}
+
+func (f filterConfig) newsFilter(now time.Time) map[string]func(newsEntry) bool {
+	maxAge := f.maxAgeDays()
+	filter := map[string]func(newsEntry) bool{
+		fmt.Sprintf("not older than %dd", maxAge): func(n newsEntry) bool {
+			return n.Time.After(now.AddDate(0, 0, -maxAge))
+		},
+	}
+	for _, expr := range f.Include {
+		re := regexp.MustCompile(expr)
+		filter[fmt.Sprintf("matches %q", expr)] = func(n newsEntry) bool {
+			return re.MatchString(n.Message)
+		}
+	}
+	for _, expr := range f.Exclude {
+		re := regexp.MustCompile(expr)
+		filter[fmt.Sprintf("doesn't match %q", expr)] = func(n newsEntry) bool {
+			return !re.MatchString(n.Message)
+		}
+	}
+	return filter
+}
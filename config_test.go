@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	env := map[string]string{
+		"HMNB_PATH":                   "result",
+		"HMNB_MAX_POSTS":              "2",
+		"HMNB_DRY_RUN":                "true",
+		"HMNB_MASTODON_SERVER":        "https://techhub.social/",
+		"HMNB_MASTODON_CLIENT_ID":     "id",
+		"HMNB_MASTODON_CLIENT_SECRET": "secret",
+		"HMNB_MASTODON_ACCESS_TOKEN":  "token",
+		"HMNB_BLUESKY_HANDLE":         "hmnews.bsky.social",
+		"HMNB_BLUESKY_APP_PASSWORD":   "password",
+		"HMNB_OPTIONS_JSON":           "result/share/doc/home-manager/options.json",
+	}
+	cfg, err := loadConfig("", lookupMap(env))
+	require.NoError(err)
+
+	assert.Equal("file:result", cfg.Source)
+	assert.Equal("result/share/doc/home-manager/options.json", cfg.OptionsJSON)
+	require.Len(cfg.Accounts, 2)
+	assert.Equal("mastodon", cfg.Accounts[0].Name)
+	assert.Equal("token", cfg.Accounts[0].Settings["access_token"])
+	assert.Equal("bluesky", cfg.Accounts[1].Name)
+	assert.Equal("hmnews.bsky.social", cfg.Accounts[1].Settings["handle"])
+
+	opts := cfg.clientOptions(cfg.Accounts[1])
+	assert.Equal("bluesky", opts.Name())
+	assert.Equal(2, opts.MaxPosts())
+	assert.True(opts.DryRun())
+	assert.Equal(hashTags, opts.HashTags())
+	assert.Contains(opts.NewsFilter(), "not older than 90d")
+	assert.Equal(90*24*time.Hour+listMargin, opts.listWindow)
+	assert.Equal(editStrategyReply, opts.EditStrategy())
+	assert.Equal(defaultOptionsSearch, opts.optionsSearch)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(os.WriteFile(path, []byte(`
+source: https://example.com/news.json
+ledgerPath: /var/lib/hmnews-bot/ledger.json
+optionsSearch: none
+maxPosts: 2
+accounts:
+  - platform: mastodon
+    dryRun: false
+    settings:
+      server: https://techhub.social/
+      client_id: id
+      client_secret: secret
+      access_token: token
+  - name: regional
+    platform: mastodon
+    maxPosts: 1
+    onEdit: none
+    onRemove: delete
+    hashtags: [HomeManager, "#NixDE"]
+    filters:
+      maxAgeDays: 30
+      include: ["new module"]
+      exclude: ["(?i)darwin"]
+    settings:
+      server: https://social.example/
+      client_id: id2
+      client_secret: secret2
+      access_token: token2
+`), 0o644))
+
+	env := map[string]string{
+		"HMNB_DRY_RUN":               "true",
+		"HMNB_MASTODON_ACCESS_TOKEN": "override",
+	}
+	cfg, err := loadConfig(path, lookupMap(env))
+	require.NoError(err)
+
+	assert.Equal("https://example.com/news.json", cfg.Source)
+	assert.Equal("/var/lib/hmnews-bot/ledger.json", cfg.LedgerPath)
+	require.Len(cfg.Accounts, 2)
+
+	primary := cfg.clientOptions(cfg.Accounts[0])
+	assert.Equal("mastodon", primary.Name())
+	assert.Equal(2, primary.MaxPosts())
+	assert.True(primary.DryRun(), "environment overrides dry-run of all accounts")
+	assert.Equal("override", cfg.Accounts[0].Settings["access_token"])
+	assert.Equal(editStrategyEdit, primary.EditStrategy(), "platform default")
+	assert.Equal(removeStrategyReport, primary.RemoveStrategy())
+	assert.Empty(primary.optionsSearch)
+
+	regional := cfg.clientOptions(cfg.Accounts[1])
+	assert.Equal("regional", regional.Name())
+	assert.Equal(1, regional.MaxPosts())
+	assert.True(regional.DryRun())
+	assert.Equal("\n#HomeManager #NixDE", regional.HashTags())
+	assert.Equal(editStrategyNone, regional.EditStrategy())
+	assert.Equal(removeStrategyDelete, regional.RemoveStrategy())
+	assert.Equal("token2", cfg.Accounts[1].Settings["access_token"])
+
+	now := time.Now()
+	passes := func(n newsEntry) bool {
+		for _, filter := range regional.NewsFilter() {
+			if !filter(n) {
+				return false
+			}
+		}
+		return true
+	}
+	assert.True(passes(newsEntry{Time: now, Message: "A new module is available: 'programs.foo'."}))
+	assert.False(passes(newsEntry{Time: now.AddDate(0, 0, -31), Message: "A new module is available: 'programs.foo'."}))
+	assert.False(passes(newsEntry{Time: now, Message: "The foo module was changed."}))
+	assert.False(passes(newsEntry{Time: now, Message: "A new module is available: 'services.skhd' (Darwin only)."}))
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	testCases := map[string]string{
+		"unknown field": `
+source: result
+maxPost: 2
+`,
+		"missing maxPosts": `
+source: result
+accounts:
+  - platform: mastodon
+`,
+		"duplicate account": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: mastodon
+  - name: mastodon
+    platform: mastodon
+`,
+		"unknown platform": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: twitter
+`,
+		"unknown setting": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: bluesky
+    settings:
+      password: x
+`,
+		"invalid filter": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: bluesky
+    filters:
+      include: ["("]
+`,
+		"invalid optionsSearch": `
+source: result
+maxPosts: 2
+optionsSearch: https://search.example/?q=
+`,
+		"invalid onEdit": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: mastodon
+    onEdit: delete
+`,
+		"invalid onRemove": `
+source: result
+maxPosts: 2
+accounts:
+  - platform: mastodon
+    onRemove: edit
+`,
+		"missing source": `
+maxPosts: 2
+`,
+	}
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			_, err := loadConfig(path, lookupMap(nil))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
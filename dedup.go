@@ -0,0 +1,275 @@
+package main
+
+import (
+	"hash/maphash"
+	"slices"
+	"strings"
+)
+
+// shingleWords is the number of words in a shingle of the post index.
+const shingleWords = 3
+
+// minSimilarShingles is the number of shingles a text needs to be compared
+// by similarity. Shorter texts, like the announcements of new modules, often
+// differ in a single word.
+const minSimilarShingles = 8
+
+// postIndex finds the posts that contain a text, after canonicalization.
+// Each post is canonicalized once, and its word shingles are indexed, so a
+// lookup only compares the text with the few posts that share its rarest
+// shingle instead of with all posts.
+type postIndex struct {
+	posts []indexedPost
+	seed  maphash.Seed
+	// shingles maps the hash of a shingle to the indexes of the posts that
+	// contain it, in ascending order.
+	shingles map[uint64][]int
+	// replies maps a post ID to the indexes of the posts replying to it.
+	replies map[string][]int
+}
+
+type indexedPost struct {
+	post
+	canonical string
+	// n and m are the part marker [n/m] of the post, if it has one.
+	n, m      int
+	hasMarker bool
+	// shingles is the number of distinct shingles of the post.
+	shingles int
+}
+
+func newPostIndex(posts []post) *postIndex {
+	ix := &postIndex{
+		posts:    make([]indexedPost, len(posts)),
+		seed:     maphash.MakeSeed(),
+		shingles: make(map[uint64][]int),
+		replies:  make(map[string][]int),
+	}
+	for i, p := range posts {
+		canonical := canonicalizePost(p.Text())
+		n, m, ok := partMarker(canonical)
+		ix.posts[i] = indexedPost{post: p, canonical: canonical, n: n, m: m, hasMarker: ok}
+
+		for _, h := range ix.hashShingles(strings.Fields(canonical)) {
+			if ids := ix.shingles[h]; len(ids) == 0 || ids[len(ids)-1] != i {
+				ix.shingles[h] = append(ids, i)
+				ix.posts[i].shingles++
+			}
+		}
+		if replyTo := p.InReplyTo(); replyTo != "" {
+			ix.replies[replyTo] = append(ix.replies[replyTo], i)
+		}
+	}
+	return ix
+}
+
+func (ix *postIndex) hashShingles(words []string) []uint64 {
+	var hashes []uint64
+	for i := 0; i+shingleWords <= len(words); i++ {
+		hashes = append(hashes, maphash.String(ix.seed, strings.Join(words[i:i+shingleWords], " ")))
+	}
+	return hashes
+}
+
+// find returns the index of the first post containing the canonical text.
+func (ix *postIndex) find(canonical string) (int, bool) {
+	matches := ix.matches(canonical, 1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	return matches[0], true
+}
+
+// matches returns the indexes of the posts containing the canonical text, at
+// most limit if limit is positive.
+func (ix *postIndex) matches(canonical string, limit int) []int {
+	if canonical == "" {
+		return nil
+	}
+	candidates, ok := ix.candidates(canonical)
+	var matches []int
+	check := func(i int) bool {
+		if strings.Contains(ix.posts[i].canonical, canonical) {
+			matches = append(matches, i)
+		}
+		return limit > 0 && len(matches) >= limit
+	}
+	if !ok {
+		// Too short to be indexed.
+		for i := range ix.posts {
+			if check(i) {
+				break
+			}
+		}
+		return matches
+	}
+	for _, i := range candidates {
+		if check(i) {
+			break
+		}
+	}
+	return matches
+}
+
+// candidates returns the posts sharing the rarest shingle of the text. The
+// first and the last word of the text are left out, as they may only be
+// part of a word of a containing post. It returns false if the text has too
+// few words for a shingle.
+func (ix *postIndex) candidates(canonical string) ([]int, bool) {
+	words := strings.Fields(canonical)
+	if len(words) < shingleWords+2 {
+		return nil, false
+	}
+	var rarest []int
+	for j, h := range ix.hashShingles(words[1 : len(words)-1]) {
+		ids := ix.shingles[h]
+		if len(ids) == 0 {
+			return nil, true
+		}
+		if j == 0 || len(ids) < len(rarest) {
+			rarest = ids
+		}
+	}
+	return rarest, true
+}
+
+// thread follows the replies from the first post of a thread. It returns a
+// slice with an element for each part the marker of the first post
+// announces, with nil for the parts that are missing. A post without marker
+// is a thread of its own.
+func (ix *postIndex) thread(first int) []post {
+	p := ix.posts[first]
+	if !p.hasMarker || p.n != 1 || p.ID() == "" {
+		return []post{p.post}
+	}
+	chain := make([]post, p.m)
+	chain[0] = p.post
+	for i := 1; i < p.m; i++ {
+		next := -1
+		for _, r := range ix.replies[chain[i-1].ID()] {
+			if rp := ix.posts[r]; rp.hasMarker && rp.n == i+1 && rp.m == p.m && rp.ID() != "" {
+				next = r
+				break
+			}
+		}
+		if next < 0 {
+			return chain
+		}
+		chain[i] = ix.posts[next].post
+	}
+	return chain
+}
+
+// updates returns the update replies to the thread, see updateNewsEntry.
+// Updates reply to the last post of the thread or continue an earlier
+// update; each is returned as the thread of its first post.
+func (ix *postIndex) updates(thread []post) [][]post {
+	ids := postIDs(thread)
+	if len(ids) == 0 {
+		return nil
+	}
+	prefix := canonicalizePost(updatePrefix)
+	var updates [][]post
+	for queue := ids[len(ids)-1:]; len(queue) > 0; queue = queue[1:] {
+		for _, r := range ix.replies[queue[0]] {
+			p := ix.posts[r]
+			if !strings.HasPrefix(p.canonical, prefix) || (p.hasMarker && p.n != 1) {
+				continue
+			}
+			update := ix.thread(r)
+			updates = append(updates, update)
+			if ids := postIDs(update); len(ids) > 0 {
+				queue = append(queue, ids[len(ids)-1])
+			}
+		}
+	}
+	return updates
+}
+
+// postedChain returns the thread of the first post if parts of it are
+// missing. Threads that are complete or have no marker return nil.
+func (ix *postIndex) postedChain(first int) []post {
+	p := ix.posts[first]
+	if !p.hasMarker || p.m == 1 {
+		return nil
+	}
+	chain := ix.thread(first)
+	if len(chain) == 1 || chain[len(chain)-1] != nil {
+		return nil
+	}
+	return chain
+}
+
+// findSplit returns the first post of a thread whose text starts the text of
+// another thread, see threadText. Such a thread posted the same message, but
+// split into other parts.
+func (ix *postIndex) findSplit(text string) (int, bool) {
+	words := strings.Fields(text)
+	if len(words) < shingleWords {
+		return 0, false
+	}
+	for _, i := range ix.shingles[ix.hashShingles(words[:shingleWords])[0]] {
+		p := ix.posts[i]
+		if !p.hasMarker || p.n != 1 || p.m == 1 {
+			continue
+		}
+		prefix := withoutMarkers(p.canonical)
+		if strings.HasPrefix(text, prefix) && (len(text) == len(prefix) || text[len(prefix)] == ' ') {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// similar returns the first post of a thread that is most similar to the
+// canonical text, and their similarity: the Jaccard index of their shingles.
+// Posts for which skip returns true are left out. It returns false if no post
+// shares a shingle or the text is too short.
+func (ix *postIndex) similar(canonical string, skip func(int) bool) (int, float64, bool) {
+	hashes := ix.hashShingles(strings.Fields(canonical))
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+	if len(hashes) < minSimilarShingles {
+		return 0, 0, false
+	}
+
+	shared := make(map[int]int)
+	for _, h := range hashes {
+		for _, i := range ix.shingles[h] {
+			shared[i]++
+		}
+	}
+	best, bestSimilarity := -1, 0.0
+	for i, n := range shared {
+		p := ix.posts[i]
+		if p.ID() == "" || p.InReplyTo() != "" || (p.hasMarker && p.n != 1) || skip(i) {
+			// Not the first post of a thread.
+			continue
+		}
+		similarity := float64(n) / float64(len(hashes)+p.shingles-n)
+		if similarity > bestSimilarity || (similarity == bestSimilarity && i < best) {
+			best, bestSimilarity = i, similarity
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	return best, bestSimilarity, true
+}
+
+// threadText returns the canonicalized text of the posts of a thread without
+// part markers, so that the threads of a message compare equal however the
+// message was split.
+func threadText(posts []string) string {
+	var words []string
+	for _, p := range posts {
+		words = append(words, strings.Fields(withoutMarkers(canonicalizePost(p)))...)
+	}
+	return strings.Join(words, " ")
+}
+
+// withoutMarkers removes the part markers from a canonicalized post and
+// normalizes its whitespace.
+func withoutMarkers(canonical string) string {
+	return strings.Join(strings.Fields(partMarkerRegexp.ReplaceAllString(canonical, "")), " ")
+}
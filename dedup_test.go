@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/mattn/go-mastodon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var dedupFixtures = []struct {
+	testdataDir string
+	client      string
+}{
+	{testdataDir: "2025-05-15T21:37:58", client: "mastodon"},
+	{testdataDir: "2025-07-02T06:47:04", client: "mastodon"},
+	{testdataDir: "2025-07-02T06:47:04", client: "bluesky"},
+	{testdataDir: "2026-02-14T04:51:46", client: "mastodon"},
+	{testdataDir: "2026-02-14T04:51:46", client: "bluesky"},
+}
+
+// loadFixture reads the news and the posts of a client from testdata.
+func loadFixture(tb testing.TB, testdataDir, client string) ([]newsEntry, *stubPostingClient) {
+	tb.Helper()
+	require := require.New(tb)
+
+	f, err := os.ReadFile(path.Join("testdata", testdataDir, "news.json"))
+	require.NoError(err)
+	var news newsFile
+	require.NoError(json.Unmarshal(f, &news))
+
+	f, err = os.ReadFile(path.Join("testdata", testdataDir, client+".json"))
+	require.NoError(err)
+	switch client {
+	case "mastodon":
+		var posts []*mastodon.Status
+		require.NoError(json.Unmarshal(f, &posts))
+		return news.Entries, stubPostingClientFromMastodonPosts(posts)
+	case "bluesky":
+		var posts []*bsky.FeedPost
+		require.NoError(json.Unmarshal(f, &posts))
+		return news.Entries, stubPostingClientFromBlueskyPosts(posts)
+	}
+	require.Failf("unknown client", "%q", client)
+	return nil, nil
+}
+
+// fixtureThreads returns the threads of all news entries, without filtering
+// by age.
+func fixtureThreads(news []newsEntry, c postingClient) []newsThread {
+	news = prepareNews(copySlice(news))
+	threads := make([]newsThread, len(news))
+	for i, n := range news {
+		threads[i] = newsThread{entry: n, posts: clientPosts(c, n.Message, c.HashTags())}
+	}
+	return threads
+}
+
+func TestPostIndexMatchesLinearSearch(t *testing.T) {
+	for _, fixture := range dedupFixtures {
+		t.Run(fmt.Sprintf("%s,%s", fixture.testdataDir, fixture.client), func(t *testing.T) {
+			assert := assert.New(t)
+
+			news, client := loadFixture(t, fixture.testdataDir, fixture.client)
+			index := newPostIndex(client.listPostsPosts)
+
+			var queries []string
+			for _, thread := range fixtureThreads(news, client) {
+				first := canonicalizePost(thread.posts[0])
+				// Texts starting and ending within a word.
+				queries = append(queries, first, first[1:len(first)-1])
+			}
+			var found int
+			for _, query := range queries {
+				want := slices.IndexFunc(index.posts, func(p indexedPost) bool {
+					return strings.Contains(p.canonical, query)
+				})
+				got, ok := index.find(query)
+				if want < 0 {
+					assert.False(ok, "%q shouldn't be found", query)
+					continue
+				}
+				found++
+				assert.True(ok, "%q should be found", query)
+				assert.Equal(want, got, "%q should be found in the first post containing it", query)
+			}
+			assert.Positive(found)
+		})
+	}
+}
+
+func TestPostIndexFind(t *testing.T) {
+	posts := []post{
+		&mastodonPost{&mastodon.Status{ID: "1", Content: "<p>Short</p>"}},
+		&mastodonPost{&mastodon.Status{ID: "2", Content: "<p>A new module is available: &#39;programs.foo&#39;. Try it!</p>"}},
+		&mastodonPost{&mastodon.Status{ID: "3", Content: "Reposted: A new module is available: 'programs.foo'. Try it!"}},
+	}
+	index := newPostIndex(posts)
+
+	testCases := map[string]struct {
+		text    string
+		wantID  string
+		wantAll []string
+	}{
+		"exact":                  {text: "A new module is available: 'programs.foo'. Try it!", wantID: "2", wantAll: []string{"2", "3"}},
+		"within words":           {text: "ew module is available: 'programs.fo", wantID: "2", wantAll: []string{"2", "3"}},
+		"too short for shingles": {text: "hort", wantID: "1", wantAll: []string{"1"}},
+		"not posted":             {text: "A new module is available: 'programs.bar'. Try it!"},
+		"empty":                  {text: ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			canonical := canonicalizePost(tc.text)
+			i, ok := index.find(canonical)
+			assert.Equal(tc.wantID != "", ok)
+			if ok {
+				assert.Equal(tc.wantID, index.posts[i].ID())
+			}
+			var all []string
+			for _, i := range index.matches(canonical, 0) {
+				all = append(all, index.posts[i].ID())
+			}
+			assert.Equal(tc.wantAll, all)
+		})
+	}
+}
+
+func BenchmarkNotYetPosted(b *testing.B) {
+	for _, fixture := range dedupFixtures {
+		b.Run(fmt.Sprintf("%s,%s", fixture.testdataDir, fixture.client), func(b *testing.B) {
+			news, client := loadFixture(b, fixture.testdataDir, fixture.client)
+			threads := fixtureThreads(news, client)
+			b.ResetTimer()
+			for range b.N {
+				notYetPosted(threads, nil, client.listPostsPosts)
+			}
+		})
+	}
+}
+
+func BenchmarkPostIndexFind(b *testing.B) {
+	for _, fixture := range dedupFixtures {
+		b.Run(fmt.Sprintf("%s,%s", fixture.testdataDir, fixture.client), func(b *testing.B) {
+			news, client := loadFixture(b, fixture.testdataDir, fixture.client)
+			index := newPostIndex(client.listPostsPosts)
+			var queries []string
+			for _, thread := range fixtureThreads(news, client) {
+				queries = append(queries, canonicalizePost(thread.posts[0]))
+			}
+			b.ResetTimer()
+			for range b.N {
+				for _, query := range queries {
+					index.find(query)
+				}
+			}
+		})
+	}
+}
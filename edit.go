@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// editStrategy is how an account handles news entries that were edited
+// after they were posted.
+type editStrategy string
+
+const (
+	// editStrategyEdit edits the posts of the entry. If the edited entry
+	// has a different number of parts, an update is posted instead.
+	editStrategyEdit editStrategy = "edit"
+	// editStrategyReply replies to the thread of the entry with an update.
+	editStrategyReply editStrategy = "reply"
+	// editStrategyNone ignores edits.
+	editStrategyNone editStrategy = "none"
+)
+
+func (s editStrategy) valid() bool {
+	switch s {
+	case editStrategyEdit, editStrategyReply, editStrategyNone:
+		return true
+	}
+	return false
+}
+
+// editSimilarity is the similarity a post must have with a news entry to be
+// taken as a post of an earlier version of the entry, see postIndex.similar.
+const editSimilarity = 0.5
+
+// updatePrefix starts the replies with the edited entry.
+const updatePrefix = "Updated: "
+
+// postEditor is implemented by clients that can edit their posts.
+type postEditor interface {
+	EditPost(ctx context.Context, id, text string) error
+}
+
+// threadEdit marks a thread of an edited news entry.
+type threadEdit struct {
+	// record is the ledger record of the earlier version of the entry.
+	record ledgerRecord
+}
+
+// postedEdit returns the edit of the thread posted for an earlier version of
+// the entry. The posts of the thread end at the first missing part.
+func postedEdit(entryID string, posted []post) *threadEdit {
+	record := ledgerRecord{EntryID: entryID, PostedAt: time.Now().UTC(), PostIDs: postIDs(posted)}
+	record.Parts = len(record.PostIDs)
+	return &threadEdit{record: record}
+}
+
+// postIDs returns the IDs of the posts up to the first missing one.
+func postIDs(posts []post) []string {
+	var ids []string
+	for _, p := range posts {
+		if p == nil {
+			break
+		}
+		ids = append(ids, p.ID())
+	}
+	return ids
+}
+
+// isUpdateOf reports whether the posts are an update reply with the message
+// of the thread posts, see updateNewsEntry. Updates have no hash tags.
+func isUpdateOf(update []post, posts []string) bool {
+	withoutHashTags := func(text string) string {
+		return strings.Join(slices.DeleteFunc(strings.Fields(text), func(w string) bool {
+			return strings.HasPrefix(w, "#")
+		}), " ")
+	}
+	return withoutHashTags(threadText(postTexts(update))) ==
+		withoutHashTags(threadText(slices.Concat([]string{updatePrefix}, posts)))
+}
+
+// postTexts returns the texts of the posts up to the first missing one.
+func postTexts(posts []post) []string {
+	var texts []string
+	for _, p := range posts {
+		if p == nil {
+			break
+		}
+		texts = append(texts, p.Text())
+	}
+	return texts
+}
+
+// messageHash identifies the version of a news entry. Changes of whitespace
+// don't change it.
+func (n newsEntry) messageHash() string {
+	sum := sha256.Sum256([]byte(flattenMessage(n.Message)))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateNewsEntry applies the edit strategy of the client to the thread of
+// an edited news entry and returns the new ledger record of the entry.
+func updateNewsEntry(ctx context.Context, client postingClient, thread newsThread) (ledgerRecord, error) {
+	record := thread.edit.record
+	record.MessageHash = thread.entry.messageHash()
+
+	if client.EditStrategy() == editStrategyEdit {
+		editor, ok := client.(postEditor)
+		if ok && len(record.PostIDs) == len(thread.posts) {
+			for i, id := range record.PostIDs {
+				if err := editor.EditPost(ctx, id, thread.posts[i]); err != nil {
+					return record, fmt.Errorf("editing post %s: %w", id, err)
+				}
+			}
+			return record, nil
+		}
+		log.Printf("Warn: can't edit %d posts into %d parts, replying instead", len(record.PostIDs), len(thread.posts))
+	}
+
+	// Updates continue the thread after the earlier updates.
+	replyTo := ""
+	if ids := slices.Concat(record.PostIDs, record.UpdateIDs); len(ids) > 0 {
+		replyTo = ids[len(ids)-1]
+	}
+	ids, err := client.CreatePostChain(ctx, clientPosts(client, updatePrefix+thread.entry.Message, ""), replyTo)
+	if err != nil {
+		return record, fmt.Errorf("replying with update: %w", err)
+	}
+	record.UpdateIDs = slices.Concat(record.UpdateIDs, ids)
+	return record, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunEditedEntry(t *testing.T) {
+	fixTypo := func(n newsEntry) newsEntry {
+		n.Message = strings.Replace(n.Message, "w30", "W30", 1)
+		return n
+	}
+	addWords := func(n newsEntry) newsEntry {
+		n.Message += strings.Repeat(" more", 20)
+		return n
+	}
+	// Home Manager derives the IDs of entries from their message, so an
+	// edited entry usually has a new ID.
+	newID := func(edit func(newsEntry) newsEntry) func(newsEntry) newsEntry {
+		return func(n newsEntry) newsEntry {
+			n = edit(n)
+			n.ID = "edited"
+			return n
+		}
+	}
+	fixLastPart := func(n newsEntry) newsEntry {
+		n.Message = strings.Replace(n.Message, "w55", "W55", 1)
+		return n
+	}
+
+	testCases := map[string]struct {
+		strategy    editStrategy
+		edit        func(newsEntry) newsEntry
+		wantEdited  []string
+		wantReplies bool
+	}{
+		"edit": {
+			strategy:   editStrategyEdit,
+			edit:       fixTypo,
+			wantEdited: []string{"0", "1", "2"},
+		},
+		"edit with more parts replies": {
+			strategy:    editStrategyEdit,
+			edit:        addWords,
+			wantReplies: true,
+		},
+		"reply": {
+			strategy:    editStrategyReply,
+			edit:        fixTypo,
+			wantReplies: true,
+		},
+		"none": {
+			strategy: editStrategyNone,
+			edit:     fixTypo,
+		},
+		"edit with new ID": {
+			strategy:   editStrategyEdit,
+			edit:       newID(fixTypo),
+			wantEdited: []string{"0", "1", "2"},
+		},
+		"edit of last part with new ID": {
+			strategy:   editStrategyEdit,
+			edit:       newID(fixLastPart),
+			wantEdited: []string{"0", "1", "2"},
+		},
+		"reply with new ID": {
+			strategy:    editStrategyReply,
+			edit:        newID(fixLastPart),
+			wantReplies: true,
+		},
+		"none with new ID": {
+			strategy: editStrategyNone,
+			edit:     newID(fixTypo),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			ctx := context.Background()
+			t.Cleanup(func() {
+				assert.NoError(os.Remove("stub.json"))
+			})
+
+			postLedger := newMemLedger()
+			// The thread of an entry whose ID changed must not be withdrawn
+			// as the thread of a removed entry.
+			client := &stubPostingClient{maxPostLen: 100, editStrategy: tc.strategy, removeStrategy: removeStrategyDelete}
+			require.NoError(run(ctx, []newsEntry{threeParts()}, []postingClient{client}, postLedger))
+			require.Len(client.createPostChainPosts, 3)
+			posted, ok := postLedger.Lookup("stub", "three")
+			require.True(ok)
+
+			edited := tc.edit(threeParts())
+			for range 2 {
+				// Editing is done once, the second run finds the updated ledger.
+				client.listPostsPosts = client.createPostChainPosts
+				require.NoError(run(ctx, []newsEntry{edited}, []postingClient{client}, postLedger))
+			}
+
+			record, ok := postLedger.Lookup("stub", edited.ID)
+			if tc.strategy == editStrategyNone && edited.ID != posted.EntryID {
+				// The edit is ignored, so nothing is recorded for the new ID.
+				require.False(ok)
+				record = posted
+			} else {
+				require.True(ok)
+			}
+			assert.Equal(posted.PostIDs, record.PostIDs)
+
+			var editedIDs []string
+			for id := range client.editedPosts {
+				editedIDs = append(editedIDs, id)
+			}
+			assert.ElementsMatch(tc.wantEdited, editedIDs)
+			if tc.wantEdited != nil {
+				var texts []string
+				for _, id := range tc.wantEdited {
+					texts = append(texts, client.editedPosts[id])
+				}
+				assert.Equal(threadText(clientPosts(client, edited.Message, client.HashTags())), threadText(texts))
+			}
+
+			replies := client.createPostChainPosts[3:]
+			if !tc.wantReplies {
+				assert.Empty(replies)
+				assert.Empty(record.UpdateIDs)
+			} else {
+				require.NotEmpty(replies)
+				assert.True(strings.HasPrefix(replies[0].Text(), updatePrefix))
+				assert.Equal("2", replies[0].InReplyTo(), "update should reply to the last post of the thread")
+				assert.Len(record.UpdateIDs, len(replies))
+			}
+			if tc.strategy == editStrategyNone {
+				assert.Equal(posted.MessageHash, record.MessageHash)
+			} else {
+				assert.Equal(edited.messageHash(), record.MessageHash)
+			}
+			assert.Empty(client.deletedPosts)
+			posted, ok = postLedger.Lookup("stub", posted.EntryID)
+			require.True(ok)
+			assert.Nil(posted.WithdrawnAt)
+		})
+	}
+}
+
+func TestRunEditedEntryUpdatesOnce(t *testing.T) {
+	fixTypo := func(n newsEntry) newsEntry {
+		n.Message = strings.Replace(n.Message, "w30", "W30", 1)
+		return n
+	}
+	testCases := map[string]struct {
+		strategy editStrategy
+		// earlier is an edit that was posted as an update before.
+		earlier func(newsEntry) newsEntry
+		edit    func(newsEntry) newsEntry
+	}{
+		"reply": {
+			strategy: editStrategyReply,
+			edit:     fixTypo,
+		},
+		"edit with more parts": {
+			strategy: editStrategyEdit,
+			edit: func(n newsEntry) newsEntry {
+				n.Message += strings.Repeat(" more", 20)
+				return n
+			},
+		},
+		"reply after earlier update": {
+			strategy: editStrategyReply,
+			earlier:  fixTypo,
+			edit: func(n newsEntry) newsEntry {
+				n.Message = strings.Replace(fixTypo(n).Message, "w55", "W55", 1)
+				return n
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			ctx := context.Background()
+			t.Cleanup(func() {
+				assert.NoError(os.Remove("stub.json"))
+			})
+
+			client := &stubPostingClient{maxPostLen: 100, editStrategy: tc.strategy}
+			require.NoError(run(ctx, []newsEntry{threeParts()}, []postingClient{client}, newMemLedger()))
+			if tc.earlier != nil {
+				client.listPostsPosts = client.createPostChainPosts
+				require.NoError(run(ctx, []newsEntry{tc.earlier(threeParts())}, []postingClient{client}, newMemLedger()))
+			}
+
+			edited := tc.edit(threeParts())
+			var counts []int
+			for range 3 {
+				// Without the ledger, the update is recognized by its posts.
+				client.listPostsPosts = client.createPostChainPosts
+				require.NoError(run(ctx, []newsEntry{edited}, []postingClient{client}, newMemLedger()))
+				counts = append(counts, len(client.createPostChainPosts))
+			}
+			assert.Equal(counts[0], counts[2], "update should be posted once")
+			assert.Empty(client.editedPosts)
+		})
+	}
+}
+
+func TestRunNewModuleIsNotEditOfOlderOne(t *testing.T) {
+	announcement := func(module string) string {
+		return "A new module is available: '" + module + "'.\n\nThe module is maintained by the community. " +
+			"Please report any issues you find with it in the Home Manager issue tracker and mention its maintainers."
+	}
+	now := time.Now()
+	older := newsEntry{ID: "older", Time: now.AddDate(0, 0, -200), Condition: true, Message: announcement("programs.foo")}
+	newer := newsEntry{ID: "newer", Time: now.AddDate(0, 0, -1), Condition: true, Message: announcement("programs.bar")}
+
+	testCases := map[string]struct {
+		news []newsEntry
+	}{
+		"older entry out of the window": {news: []newsEntry{older, newer}},
+		"older entry removed":           {news: []newsEntry{newer}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			t.Cleanup(func() {
+				assert.NoError(os.Remove("stub.json"))
+			})
+
+			client := &stubPostingClient{
+				maxPostLen:   500,
+				editStrategy: editStrategyEdit,
+				newsFilter: map[string]func(newsEntry) bool{
+					"not older than 90d": func(n newsEntry) bool { return n.Time.After(now.AddDate(0, 0, -postWindow)) },
+				},
+				listPostsPosts: []post{&mastodonPost{&mastodon.Status{
+					ID:        "older",
+					CreatedAt: older.Time.Add(time.Hour),
+					Content:   "<p>" + older.Message + "</p>",
+				}}},
+			}
+			require.Greater(similarity(t, older.Message, newer.Message), editSimilarity, "announcements should share the template")
+
+			require.NoError(run(context.Background(), tc.news, []postingClient{client}, newMemLedger()))
+			assert.Empty(client.editedPosts, "the older entry shouldn't be edited")
+			require.Len(client.createPostChainPosts, 1)
+			assert.Empty(client.createPostChainPosts[0].InReplyTo())
+			assert.Contains(client.createPostChainPosts[0].Text(), "programs.bar")
+		})
+	}
+}
+
+// similarity returns the similarity of the messages, see postIndex.similar.
+func similarity(t *testing.T, a, b string) float64 {
+	index := newPostIndex([]post{&mastodonPost{&mastodon.Status{ID: "a", Content: a}}})
+	_, similarity, ok := index.similar(canonicalizePost(b), func(int) bool { return false })
+	require.True(t, ok)
+	return similarity
+}
+
+func TestNotYetPostedDetectsEdits(t *testing.T) {
+	status := func(id, content string) post {
+		return &mastodonPost{&mastodon.Status{ID: mastodon.ID(id), Content: "<p>" + content + "</p>"}}
+	}
+	thread := func(message string) newsThread {
+		return newsThread{entry: newsEntry{ID: message}, posts: splitIntoPosts(message, hashTags, 500, mastodonLen)}
+	}
+	const (
+		nixGL      = "A new module is available: 'nixGL'. NixGL solve the \"OpenGL\" problem with nix. The 'nixGL' module provides integration of NixGL into Home Manager. See the \"GPU on non-NixOS systems\" section in the Home Manager manual for more."
+		nixGLTypo  = "A new module is available: 'nixGL'. NixGL solve the \"OpenGL\" problem with nix. The 'nixGL' module provides integration of NixGL into Home Manager. See the \"GPU on non-NixOS systems\" section in the Home Manager mantual for more."
+		nixGLOther = "A new module is available: 'nixGL'. NixGL solve the \"OpenGL\" problem with nix. The 'nixGL' module provides integration of NixGL into Home Manager. See the \"GPU on non-NixOS systems\" section in the Home Manager manual for details."
+	)
+
+	testCases := map[string]struct {
+		threads  []newsThread
+		posts    []post
+		wantEdit map[string][]string
+	}{
+		"typo fixed": {
+			threads:  []newsThread{thread(nixGL)},
+			posts:    []post{status("a", nixGLTypo)},
+			wantEdit: map[string][]string{nixGL: {"a"}},
+		},
+		"post of another entry": {
+			threads: []newsThread{thread(nixGL), thread(nixGLOther)},
+			posts:   []post{status("a", nixGLOther)},
+		},
+		"short entries": {
+			threads: []newsThread{thread("A new module is available: 'programs.foo'.")},
+			posts:   []post{status("a", "A new module is available: 'programs.bar'.")},
+		},
+		"replies": {
+			threads: []newsThread{thread(nixGL)},
+			posts: []post{&mastodonPost{&mastodon.Status{
+				ID: "b", Content: "<p>" + nixGLTypo + "</p>", InReplyToID: "a",
+			}}},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			edits := make(map[string][]string)
+			threads, _ := notYetPosted(tc.threads, nil, tc.posts)
+			for _, thread := range threads {
+				if thread.edit != nil {
+					edits[thread.entry.ID] = thread.edit.record.PostIDs
+				}
+			}
+			if tc.wantEdit == nil {
+				assert.Empty(edits)
+			} else {
+				assert.Equal(tc.wantEdit, edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// maxTagLen is the maximum length of a Bluesky tag, in graphemes.
+const maxTagLen = 64
+
+type facetKind int
+
+const (
+	facetLink facetKind = iota
+	facetTag
+	facetMention
+	// facetOption is an option path, linked to the options search.
+	facetOption
+)
+
+// facetToken is a link, tag, mention or option path in the text of a post.
+type facetToken struct {
+	kind facetKind
+	// start and end are the byte offsets of the token in the text.
+	start, end int
+	// value is the URI of a link, the tag without '#', the handle of a
+	// mention without '@', or the options search URI of an option path.
+	value string
+}
+
+// scanFacets finds the links, tags and mentions in the text, and the option
+// paths if an options search is given. Tokens start a word, after opening
+// punctuation, and end before trailing punctuation.
+func scanFacets(text, optionsSearch string) []facetToken {
+	var tokens []facetToken
+	for _, w := range scanAtoms(text, false) {
+		start := w.start
+		for start < w.end {
+			r, size := utf8.DecodeRuneInString(text[start:])
+			if !strings.ContainsRune(`([{<"'“‘`, r) {
+				break
+			}
+			start += size
+		}
+		word := text[start:w.end]
+
+		switch {
+		case strings.HasPrefix(word, "https://") || strings.HasPrefix(word, "http://"):
+			uri := trimURL(word)
+			if strings.Contains(uri[strings.Index(uri, "://")+3:], ".") {
+				tokens = append(tokens, facetToken{kind: facetLink, start: start, end: start + len(uri), value: uri})
+			}
+		case strings.HasPrefix(word, "#"):
+			tag := strings.TrimRightFunc(word[1:], unicode.IsPunct)
+			if tag != "" && graphemeLen(tag) <= maxTagLen && strings.ContainsFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) }) &&
+				!strings.ContainsFunc(tag, func(r rune) bool { return r == '#' || unicode.IsControl(r) }) {
+				tokens = append(tokens, facetToken{kind: facetTag, start: start, end: start + 1 + len(tag), value: tag})
+			}
+		case strings.HasPrefix(word, "@"):
+			handle := strings.TrimRightFunc(word[1:], unicode.IsPunct)
+			if _, err := syntax.ParseHandle(handle); err == nil {
+				tokens = append(tokens, facetToken{kind: facetMention, start: start, end: start + 1 + len(handle), value: handle})
+			}
+		case optionsSearch != "":
+			if paths := optionPaths(word); len(paths) > 0 && paths[0].start == 0 {
+				tokens = append(tokens, facetToken{
+					kind: facetOption, start: start, end: start + paths[0].end,
+					value: optionSearchURL(optionsSearch, paths[0].path),
+				})
+			}
+		}
+	}
+	return tokens
+}
+
+// trimURL removes the punctuation that ends a sentence or encloses the URL
+// from its end.
+func trimURL(uri string) string {
+	for {
+		r, size := utf8.DecodeLastRuneInString(uri)
+		switch {
+		case strings.ContainsRune(`.,;:!?'"”’>`, r):
+		case r == ')' && strings.Count(uri, "(") < strings.Count(uri, ")"):
+		case r == ']' && strings.Count(uri, "[") < strings.Count(uri, "]"):
+		default:
+			return uri
+		}
+		uri = uri[:len(uri)-size]
+	}
+}
+
+// buildFacets returns the rich text facets for the links, tags, mentions and
+// option paths in the text, see scanFacets. Mentions are resolved to DIDs,
+// and left out if that fails.
+func buildFacets(ctx context.Context, text, optionsSearch string, resolve func(context.Context, syntax.Handle) (syntax.DID, error)) []*bsky.RichtextFacet {
+	var facets []*bsky.RichtextFacet
+	for _, token := range scanFacets(text, optionsSearch) {
+		var feature bsky.RichtextFacet_Features_Elem
+		switch token.kind {
+		case facetLink, facetOption:
+			feature.RichtextFacet_Link = &bsky.RichtextFacet_Link{Uri: token.value}
+		case facetTag:
+			feature.RichtextFacet_Tag = &bsky.RichtextFacet_Tag{Tag: token.value}
+		case facetMention:
+			did, err := resolve(ctx, syntax.Handle(token.value))
+			if err != nil {
+				log.Printf("Warn: not linking mention of %s: %v", token.value, err)
+				continue
+			}
+			feature.RichtextFacet_Mention = &bsky.RichtextFacet_Mention{Did: did.String()}
+		}
+		facets = append(facets, &bsky.RichtextFacet{
+			Index: &bsky.RichtextFacet_ByteSlice{
+				ByteStart: int64(token.start),
+				ByteEnd:   int64(token.end),
+			},
+			Features: []*bsky.RichtextFacet_Features_Elem{&feature},
+		})
+	}
+	return facets
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/stretchr/testify/assert"
+)
+
+// resolveFakeHandle resolves the handles of example.com.
+func resolveFakeHandle(_ context.Context, handle syntax.Handle) (syntax.DID, error) {
+	if handle.Normalize() != "example.com" {
+		return "", errors.New("handle not found")
+	}
+	return "did:plc:example", nil
+}
+
+func TestBuildFacets(t *testing.T) {
+	testCases := map[string]struct {
+		text string
+		want []string
+	}{
+		"hash tags": {
+			text: "A new module is available. [1/2]\n#NixOS #Nix #HomeManager",
+			want: []string{"tag NixOS", "tag Nix", "tag HomeManager"},
+		},
+		"repeated link": {
+			text: "See https://example.com/a and again https://example.com/a.",
+			want: []string{"link https://example.com/a", "link https://example.com/a"},
+		},
+		"trailing punctuation": {
+			text: "See <https://github.com/iffse/pay-respects/blob/main/rules.md>. Or (https://example.com/b), \"https://example.com/c\"!",
+			want: []string{
+				"link https://github.com/iffse/pay-respects/blob/main/rules.md",
+				"link https://example.com/b",
+				"link https://example.com/c",
+			},
+		},
+		"parentheses in link": {
+			text: "See https://en.wikipedia.org/wiki/Nix_(package_manager).",
+			want: []string{"link https://en.wikipedia.org/wiki/Nix_(package_manager)"},
+		},
+		"no tags in links and words": {
+			text: "Written in C# and documented at https://example.com/#options, see #1 or issue#2.",
+			want: []string{"link https://example.com/#options"},
+		},
+		"tag punctuation": {
+			text: "Try it (#NixOS), #home-manager! and #42nd.",
+			want: []string{"tag NixOS", "tag home-manager", "tag 42nd"},
+		},
+		"non-ASCII before facets": {
+			text: "Ünïcode 👩‍💻 → https://example.com/ü #Grüße",
+			want: []string{"link https://example.com/ü", "tag Grüße"},
+		},
+		"mentions": {
+			text: "Thanks @example.com, @unknown.example.org and mail@example.com.",
+			want: []string{"mention example.com did:plc:example"},
+		},
+		"option paths": {
+			text: "A new module is available: 'programs.kickoff'. See programs.kickoff.settings, home.nix and https://example.com/programs.foo.",
+			want: []string{
+				"link https://search.example/?q=programs.kickoff",
+				"link https://search.example/?q=programs.kickoff.settings",
+				"link https://example.com/programs.foo",
+			},
+		},
+		"no facets": {
+			text: "A new module is available: 'foo.bar'. Use http:// or https://localhost.",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			facets := buildFacets(context.Background(), tc.text, testOptionsSearch, resolveFakeHandle)
+			assert.Equal(t, tc.want, checkFacets(t, tc.text, facets))
+		})
+	}
+}
+
+func FuzzBuildFacets(f *testing.F) {
+	for _, seed := range []string{
+		"A new module is available. [1/2]\n#NixOS #Nix #HomeManager",
+		"See <https://example.com/a>. And (https://example.com/b_(c)), again https://example.com/a!",
+		"Ünïcode 👩‍💻 → https://example.com/ü #Grüße @example.com.",
+		"C# #1 ## #‍ @ @. http:// 'programs.foo.<name>.bar' (home.file)",
+		"\xff#tag\xfe https://example.com/\xff",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		checkFacets(t, text, buildFacets(context.Background(), text, testOptionsSearch, resolveFakeHandle))
+	})
+}
+
+// checkFacets checks that the facets are ordered, don't overlap, start and
+// end on character boundaries and cover the text of their feature. It
+// describes each facet by its feature.
+func checkFacets(t *testing.T, text string, facets []*bsky.RichtextFacet) []string {
+	t.Helper()
+	var descriptions []string
+	prevEnd := 0
+	for _, facet := range facets {
+		start, end := int(facet.Index.ByteStart), int(facet.Index.ByteEnd)
+		if start < prevEnd || start >= end || end > len(text) {
+			t.Fatalf("facet [%d, %d) is out of order or out of range of %q", start, end, text)
+		}
+		prevEnd = end
+		if !utf8.RuneStart(text[start]) || (end < len(text) && !utf8.RuneStart(text[end])) {
+			t.Fatalf("facet [%d, %d) doesn't fall on character boundaries of %q", start, end, text)
+		}
+		if utf8.ValidString(text) && !utf8.ValidString(text[start:end]) {
+			t.Fatalf("facet [%d, %d) of %q isn't valid UTF-8", start, end, text)
+		}
+		if len(facet.Features) != 1 {
+			t.Fatalf("facet [%d, %d) has %d features", start, end, len(facet.Features))
+		}
+
+		var covered, description string
+		switch feature := facet.Features[0]; {
+		case feature.RichtextFacet_Link != nil:
+			covered = feature.RichtextFacet_Link.Uri
+			if optionSearchURL(testOptionsSearch, text[start:end]) == covered {
+				covered = text[start:end]
+			}
+			description = "link " + feature.RichtextFacet_Link.Uri
+		case feature.RichtextFacet_Tag != nil:
+			covered = "#" + feature.RichtextFacet_Tag.Tag
+			description = "tag " + feature.RichtextFacet_Tag.Tag
+		case feature.RichtextFacet_Mention != nil:
+			covered = text[start:end]
+			description = fmt.Sprintf("mention %s %s", text[start+1:end], feature.RichtextFacet_Mention.Did)
+		}
+		if text[start:end] != covered {
+			t.Fatalf("facet [%d, %d) covers %q instead of %q", start, end, text[start:end], covered)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ledger keeps track of which news entries were posted to which platform.
+type ledger interface {
+	Lookup(platform, entryID string) (ledgerRecord, bool)
+	Record(platform string, record ledgerRecord) error
+	// Records returns the records of the platform, ordered by entry ID.
+	Records(platform string) []ledgerRecord
+}
+
+type ledgerRecord struct {
+	EntryID  string    `json:"entryId"`
+	PostedAt time.Time `json:"postedAt"`
+	// PostIDs are the status IDs (Mastodon) or AT-URIs (Bluesky) of the
+	// posts created for the entry, in thread order.
+	PostIDs []string `json:"postIds"`
+	// Parts is the number of posts of the thread. A record with fewer
+	// PostIDs is of a thread that was only partially posted.
+	Parts int `json:"parts,omitempty"`
+	// MessageHash identifies the posted version of the entry, see
+	// newsEntry.messageHash.
+	MessageHash string `json:"messageHash,omitempty"`
+	// UpdateIDs are the posts replying with edited versions of the entry,
+	// or with the documentation of the module it announces.
+	UpdateIDs []string `json:"updateIds,omitempty"`
+	// WithdrawnAt is set when the posts were deleted or retracted, because
+	// the entry was removed from the news.
+	WithdrawnAt *time.Time `json:"withdrawnAt,omitempty"`
+}
+
+// complete reports whether all posts of the thread were created. Records
+// without Parts are from before partial threads were recorded and complete.
+func (r ledgerRecord) complete() bool {
+	return len(r.PostIDs) >= r.Parts
+}
+
+type ledgerFile struct {
+	Platforms map[string]map[string]ledgerRecord `json:"platforms"`
+}
+
+// fileLedger is a ledger backed by a JSON file. The whole file is rewritten
+// on every new record.
+type fileLedger struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]map[string]ledgerRecord // platform -> entry ID -> record
+}
+
+func newFileLedger(path string) (*fileLedger, error) {
+	l := &fileLedger{
+		path:    path,
+		records: make(map[string]map[string]ledgerRecord),
+	}
+	f, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading ledger file %q: %w", path, err)
+	}
+	var lf ledgerFile
+	if err := json.Unmarshal(f, &lf); err != nil {
+		return nil, fmt.Errorf("unmarshaling ledger file %q: %w", path, err)
+	}
+	for platform, records := range lf.Platforms {
+		if records != nil {
+			l.records[platform] = records
+		}
+	}
+	return l, nil
+}
+
+// newMemLedger returns a ledger that is not persisted.
+func newMemLedger() *fileLedger {
+	return &fileLedger{records: make(map[string]map[string]ledgerRecord)}
+}
+
+func (l *fileLedger) Lookup(platform, entryID string) (ledgerRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record, ok := l.records[platform][entryID]
+	return record, ok
+}
+
+func (l *fileLedger) Record(platform string, record ledgerRecord) error {
+	if record.EntryID == "" {
+		return errors.New("recording entry without ID")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.records[platform] == nil {
+		l.records[platform] = make(map[string]ledgerRecord)
+	}
+	l.records[platform][record.EntryID] = record
+	return l.persist()
+}
+
+func (l *fileLedger) Records(platform string) []ledgerRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := slices.Collect(maps.Values(l.records[platform]))
+	slices.SortFunc(records, func(a, b ledgerRecord) int { return strings.Compare(a.EntryID, b.EntryID) })
+	return records
+}
+
+func (l *fileLedger) persist() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ledgerFile{Platforms: l.records}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling ledger: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating temporary ledger file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing temporary ledger file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary ledger file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("replacing ledger file %q: %w", l.path, err)
+	}
+	return nil
+}
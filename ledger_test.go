@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLedger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "ledger.json")
+
+	l, err := newFileLedger(path)
+	require.NoError(err)
+	_, ok := l.Lookup("mastodon", "abc")
+	assert.False(ok)
+
+	postedAt := time.Date(2025, 5, 15, 21, 37, 58, 0, time.UTC)
+	require.NoError(l.Record("mastodon", ledgerRecord{
+		EntryID:  "abc",
+		PostedAt: postedAt,
+		PostIDs:  []string{"1", "2"},
+	}))
+	require.NoError(l.Record("bluesky", ledgerRecord{
+		EntryID:  "abc",
+		PostedAt: postedAt,
+		PostIDs:  []string{"at://did:plc:x/app.bsky.feed.post/1"},
+	}))
+	assert.Error(l.Record("bluesky", ledgerRecord{}), "records without entry ID are rejected")
+
+	reopened, err := newFileLedger(path)
+	require.NoError(err)
+	record, ok := reopened.Lookup("mastodon", "abc")
+	require.True(ok)
+	assert.Equal([]string{"1", "2"}, record.PostIDs)
+	assert.True(postedAt.Equal(record.PostedAt))
+	record, ok = reopened.Lookup("bluesky", "abc")
+	require.True(ok)
+	assert.Equal([]string{"at://did:plc:x/app.bsky.feed.post/1"}, record.PostIDs)
+	_, ok = reopened.Lookup("mastodon", "def")
+	assert.False(ok)
+
+	require.NoError(reopened.Record("mastodon", ledgerRecord{EntryID: "0ab", PostedAt: postedAt}))
+	var ids []string
+	for _, record := range reopened.Records("mastodon") {
+		ids = append(ids, record.EntryID)
+	}
+	assert.Equal([]string{"0ab", "abc"}, ids)
+	assert.Empty(reopened.Records("matrix"))
+}
+
+func TestLedgerRecordComplete(t *testing.T) {
+	testCases := map[string]struct {
+		record ledgerRecord
+		want   bool
+	}{
+		"without parts":   {record: ledgerRecord{PostIDs: []string{"1"}}, want: true},
+		"all parts":       {record: ledgerRecord{PostIDs: []string{"1", "2"}, Parts: 2}, want: true},
+		"missing parts":   {record: ledgerRecord{PostIDs: []string{"1"}, Parts: 3}, want: false},
+		"nothing created": {record: ledgerRecord{Parts: 1}, want: false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.record.complete())
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// linkCardTimeout limits fetching a page or its thumbnail, so that a
+	// slow page doesn't hold up posting.
+	linkCardTimeout = 10 * time.Second
+	// maxLinkCardPage is how much of a page is read for its metadata, which
+	// is in the head.
+	maxLinkCardPage = 512 << 10
+	// maxLinkCardThumb is the size limit of Bluesky for thumbnail blobs.
+	maxLinkCardThumb = 1_000_000
+)
+
+// linkCard is the preview of a linked page, from its OpenGraph metadata.
+type linkCard struct {
+	title       string
+	description string
+	// thumb is the image of the page and thumbType its media type. The card
+	// has no thumbnail if thumb is nil.
+	thumb     []byte
+	thumbType string
+}
+
+// linkCardFetcher fetches the link cards of pages. Pages are fetched once,
+// failed fetches aren't retried either.
+type linkCardFetcher struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cards map[string]linkCardResult
+}
+
+type linkCardResult struct {
+	card *linkCard
+	err  error
+}
+
+func newLinkCardFetcher(client *http.Client) *linkCardFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: linkCardTimeout}
+	}
+	return &linkCardFetcher{client: client, cards: make(map[string]linkCardResult)}
+}
+
+// fetch returns the link card of the page.
+func (f *linkCardFetcher) fetch(ctx context.Context, pageURL string) (*linkCard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if res, ok := f.cards[pageURL]; ok {
+		return res.card, res.err
+	}
+	card, err := f.fetchPage(ctx, pageURL)
+	f.cards[pageURL] = linkCardResult{card: card, err: err}
+	return card, err
+}
+
+func (f *linkCardFetcher) fetchPage(ctx context.Context, pageURL string) (*linkCard, error) {
+	resp, err := f.get(ctx, pageURL, "text/html", "application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	meta := parsePageMeta(io.LimitReader(resp.Body, maxLinkCardPage))
+	card := &linkCard{
+		title:       meta.first("og:title", "twitter:title", "title"),
+		description: meta.first("og:description", "twitter:description", "description"),
+	}
+	if card.title == "" {
+		return nil, fmt.Errorf("page %s has no title", pageURL)
+	}
+
+	image := meta.first("og:image", "og:image:url", "og:image:secure_url", "twitter:image")
+	if image == "" {
+		return card, nil
+	}
+	imageURL, err := resp.Request.URL.Parse(image)
+	if err != nil {
+		log.Printf("Warn: link card of %s has no thumbnail: parsing image URL: %v", pageURL, err)
+		return card, nil
+	}
+	card.thumb, card.thumbType, err = f.fetchThumb(ctx, imageURL.String())
+	if err != nil {
+		log.Printf("Warn: link card of %s has no thumbnail: %v", pageURL, err)
+	}
+	return card, nil
+}
+
+func (f *linkCardFetcher) fetchThumb(ctx context.Context, imageURL string) ([]byte, string, error) {
+	resp, err := f.get(ctx, imageURL, "image/")
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	thumb, err := io.ReadAll(io.LimitReader(resp.Body, maxLinkCardThumb+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %s: %w", imageURL, err)
+	}
+	if len(thumb) > maxLinkCardThumb {
+		return nil, "", fmt.Errorf("image %s is larger than %d bytes", imageURL, maxLinkCardThumb)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return thumb, mediaType, nil
+}
+
+// get fetches an HTTP(S) URL whose media type starts with one of the prefixes.
+func (f *linkCardFetcher) get(ctx context.Context, rawURL string, mediaTypes ...string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("fetching %s: unsupported scheme %q", rawURL, u.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", "hmnews-bot")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", rawURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", rawURL, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	for _, prefix := range mediaTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return resp, nil
+		}
+	}
+	_ = resp.Body.Close()
+	return nil, fmt.Errorf("fetching %s: unexpected content type %q", rawURL, mediaType)
+}
+
+// pageMeta maps the properties and names of the meta elements of a page to
+// their content. The title element is kept as "title".
+type pageMeta map[string]string
+
+// first returns the first of the keys with content.
+func (m pageMeta) first(keys ...string) string {
+	for _, key := range keys {
+		if v := m[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parsePageMeta reads the metadata from the head of an HTML page. Where a
+// key is set more than once, the first content is kept.
+func parsePageMeta(r io.Reader) pageMeta {
+	meta := make(pageMeta)
+	set := func(key, content string) {
+		content = strings.Join(strings.Fields(content), " ")
+		if key != "" && meta[key] == "" {
+			meta[key] = content
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// The end of the page, or of what was read of it.
+			return meta
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "body":
+				return meta
+			case "title":
+				inTitle = true
+			case "meta":
+				var key, content string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(attr.Val)
+						}
+					case "content":
+						content = attr.Val
+					}
+				}
+				set(key, content)
+			}
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "head":
+				return meta
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				set("title", string(z.Text()))
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linkCardRequests counts the requests of each path to a linkCardServer.
+type linkCardRequests struct {
+	mu sync.Mutex
+	// done is signaled when a request was served.
+	done     *sync.Cond
+	counts   map[string]int
+	inFlight map[string]int
+}
+
+func newLinkCardRequests() *linkCardRequests {
+	r := &linkCardRequests{counts: make(map[string]int), inFlight: make(map[string]int)}
+	r.done = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *linkCardRequests) serve(path string, handler func()) {
+	r.mu.Lock()
+	r.counts[path]++
+	r.inFlight[path]++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inFlight[path]--
+		r.mu.Unlock()
+		r.done.Broadcast()
+	}()
+	handler()
+}
+
+// count returns the number of requests of the path, once none is served
+// anymore, like the requests a client gave up on.
+func (r *linkCardRequests) count(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.inFlight[path] > 0 {
+		r.done.Wait()
+	}
+	return r.counts[path]
+}
+
+// linkCardServer serves pages with and without OpenGraph metadata. It counts
+// the requests of each path.
+func linkCardServer(t *testing.T) (*httptest.Server, *linkCardRequests) {
+	requests := newLinkCardRequests()
+	page := func(head string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<!DOCTYPE html><html><head>" + head + "</head><body><p>Body</p></body></html>"))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og", page(`<title>Page title</title>
+		<meta property="og:title" content="Home Manager
+			Manual">
+		<meta property="og:description" content="The manual of Home Manager.">
+		<meta property="og:image" content="/thumb.png">`))
+	mux.HandleFunc("/title", page(`<title>  Only a
+		title </title><meta name="description" content="Described.">`))
+	mux.HandleFunc("/no-title", page(`<meta charset="utf-8">`))
+	mux.HandleFunc("/large-thumb", page(`<meta property="og:title" content="Large"><meta property="og:image" content="/large.png">`))
+	mux.HandleFunc("/missing-thumb", page(`<meta property="og:title" content="Missing"><meta property="og:image" content="/missing.png">`))
+	mux.HandleFunc("/text", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("<title>Not HTML</title>"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		page(`<title>Slow</title>`)(w, r)
+	})
+	mux.HandleFunc("/thumb.png", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(bytes.Repeat([]byte{0}, maxLinkCardThumb+1))
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.serve(r.URL.Path, func() { mux.ServeHTTP(w, r) })
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestLinkCardFetcher(t *testing.T) {
+	server, requests := linkCardServer(t)
+
+	testCases := map[string]struct {
+		want    *linkCard
+		wantErr bool
+	}{
+		"/og": {want: &linkCard{
+			title:       "Home Manager Manual",
+			description: "The manual of Home Manager.",
+			thumb:       []byte("png"),
+			thumbType:   "image/png",
+		}},
+		"/title":         {want: &linkCard{title: "Only a title", description: "Described."}},
+		"/large-thumb":   {want: &linkCard{title: "Large"}},
+		"/missing-thumb": {want: &linkCard{title: "Missing"}},
+		"/no-title":      {wantErr: true},
+		"/text":          {wantErr: true},
+		"/not-found":     {wantErr: true},
+		"/slow":          {wantErr: true},
+	}
+
+	for path, tc := range testCases {
+		t.Run(path, func(t *testing.T) {
+			assert := assert.New(t)
+			client := *server.Client()
+			client.Timeout = 100 * time.Millisecond
+			fetcher := newLinkCardFetcher(&client)
+
+			for range 2 {
+				card, err := fetcher.fetch(context.Background(), server.URL+path)
+				if tc.wantErr {
+					assert.Error(err)
+				} else {
+					assert.NoError(err)
+				}
+				assert.Equal(tc.want, card)
+			}
+			assert.Equal(1, requests.count(path), "page should be fetched once")
+		})
+	}
+}
+
+func TestBlueskyLinkCardEmbed(t *testing.T) {
+	pages, _ := linkCardServer(t)
+	pds := &fakePDS{}
+	server := httptest.NewServer(pds)
+	defer server.Close()
+	session, err := newBlueskySession(context.Background(),
+		&xrpc.Client{Client: server.Client(), Host: server.URL}, "test.bsky.social", "password", "")
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		posts     []string
+		wantURI   string
+		wantTitle string
+		wantThumb bool
+	}{
+		"card with thumbnail": {
+			posts:     []string{"A new module is available. [1/2]", "See " + pages.URL + "/og. And " + pages.URL + "/title. [2/2]"},
+			wantURI:   pages.URL + "/og",
+			wantTitle: "Home Manager Manual",
+			wantThumb: true,
+		},
+		"card without thumbnail": {
+			posts:     []string{"See <" + pages.URL + "/title>."},
+			wantURI:   pages.URL + "/title",
+			wantTitle: "Only a title",
+		},
+		"failed fetch": {
+			posts: []string{"See " + pages.URL + "/not-found."},
+		},
+		"no link": {
+			posts: []string{"A new module is available: 'programs.foo'. #NixOS"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			client := &blueskyClient{
+				xrpcClient:          session,
+				blueskyClientConfig: blueskyClientConfig{linkCards: newLinkCardFetcher(pages.Client())},
+			}
+
+			embed := client.linkCardEmbed(context.Background(), tc.posts)
+			if tc.wantURI == "" {
+				assert.Nil(embed)
+				return
+			}
+			require.NotNil(t, embed)
+			require.NotNil(t, embed.EmbedExternal)
+			external := embed.EmbedExternal.External
+			assert.Equal(tc.wantURI, external.Uri)
+			assert.Equal(tc.wantTitle, external.Title)
+			if tc.wantThumb {
+				require.NotNil(t, external.Thumb)
+				assert.Equal("image/png", external.Thumb.MimeType)
+				assert.Equal(int64(len("png")), external.Thumb.Size)
+			} else {
+				assert.Nil(external.Thumb)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"log"
@@ -14,7 +15,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mattn/go-mastodon"
 	"github.com/microcosm-cc/bluemonday"
 )
 
@@ -24,124 +24,81 @@ const (
 )
 
 func main() {
-	ctx := context.Background()
-
-	path := os.Getenv("HMNB_PATH")
-	if path == "" {
-		log.Fatal("HMNB_PATH not set")
-	}
-	maxPostsStr := os.Getenv("HMNB_MAX_POSTS")
-	if maxPostsStr == "" {
-		log.Fatal("HMNB_MAX_POSTS not set")
-	}
-	maxPosts, err := strconv.Atoi(maxPostsStr)
-	if err != nil {
-		log.Fatalf("parsing HMNB_MAX_POSTS: %v", err)
-	}
-	dryRunStr := os.Getenv("HMNB_DRY_RUN")
-	dryRun := false
-	if dryRunStr != "" {
-		if dryRun, err = strconv.ParseBool(dryRunStr); err != nil {
-			log.Fatalf("parsing HMNB_DRY_RUN: %v", err)
-		}
-	}
-	mastodonServer := os.Getenv("HMNB_MASTODON_SERVER")
-	if mastodonServer == "" {
-		log.Fatal("HMNB_MASTODON_SERVER not set")
-	}
-	mastodonClientID := os.Getenv("HMNB_MASTODON_CLIENT_ID")
-	if mastodonClientID == "" {
-		log.Fatal("HMNB_MASTODON_CLIENT_ID not set")
-	}
-	mastodonClientSecret := os.Getenv("HMNB_MASTODON_CLIENT_SECRET")
-	if mastodonClientSecret == "" {
-		log.Fatal("HMNB_MASTODON_CLIENT_SECRET not set")
-	}
-	mastodonAccessToken := os.Getenv("HMNB_MASTODON_ACCESS_TOKEN")
-	if mastodonAccessToken == "" {
-		log.Fatal("HMNB_MASTODON_ACCESS_TOKEN not set")
-	}
-	blueskyHandle := os.Getenv("HMNB_BLUESKY_HANDLE")
-	if blueskyHandle == "" {
-		log.Fatal("HMNB_BLUESKY_HANDLE not set")
-	}
-	blueskyAppPassword := os.Getenv("HMNB_BLUESKY_APP_PASSWORD")
-	if blueskyAppPassword == "" {
-		log.Fatal("HMNB_BLUESKY_APP_PASSWORD not set")
-	}
-
-	f, err := os.ReadFile(path)
-	if err != nil {
-		log.Fatalf("reading file at %q: %v", path, err)
-	}
-	var newsFile newsFile
-	if err := json.Unmarshal(f, &newsFile); err != nil {
-		log.Fatalf("unmarshaling news file: %v", err)
-	}
-
-	mastodonC := newMastodonClient(&mastodon.Config{
-		Server:       mastodonServer,
-		ClientID:     mastodonClientID,
-		ClientSecret: mastodonClientSecret,
-		AccessToken:  mastodonAccessToken,
-	}, mastodonClientConfig{
-		dryRun:   dryRun,
-		maxPosts: maxPosts,
-		newsFilter: map[string]func(newsEntry) bool{
-			"not older than 90d": inTimeWindow,
-		},
-	})
-
-	bluesskyC, err := newBlueskyClient(ctx, blueskyClientConfig{
-		handle:   blueskyHandle,
-		appkey:   blueskyAppPassword,
-		dryRun:   dryRun,
-		maxPosts: maxPosts,
-		newsFilter: map[string]func(newsEntry) bool{
-			"not older than 90d": inTimeWindow,
-		},
-	})
-	if err != nil {
-		log.Fatalf("creating Bluesky client: %v", err)
-	}
-
-	if err := run(ctx, newsFile.Entries, []postingClient{mastodonC, bluesskyC}); err != nil {
+	if err := runCLI(context.Background(), os.Args[1:], os.Stdout, os.LookupEnv); err != nil {
 		log.Fatal(err.Error())
 	}
 }
 
 type post interface {
+	ID() string
+	// InReplyTo is the ID of the post this post replies to, if any.
+	InReplyTo() string
 	Text() string
 }
 
 type postingClient interface {
 	NewsFilter() map[string]func(newsEntry) bool
 	ListPosts(ctx context.Context) ([]post, error)
-	CreatePostChain(ctx context.Context, postChain []string) error
+	// CreatePostChain posts the chain as a thread and returns the IDs of the
+	// created posts. On error, the IDs of the posts created so far are returned.
+	// If inReplyTo is set, the thread continues the thread of that post.
+	CreatePostChain(ctx context.Context, postChain []string, inReplyTo string) ([]string, error)
+	// DeletePost deletes a post of the account. Deleting a post that
+	// doesn't exist anymore succeeds.
+	DeletePost(ctx context.Context, id string) error
+	// Name of the account, unique among all clients.
+	Name() string
 	PlatformName() string
 	MaxPosts() int
 	MaxPostLen() int
+	// PostLen is the length of the text as the platform counts it against
+	// MaxPostLen.
+	PostLen(text string) int
+	// RenderMessage renders a message formatted by formatMessage for the
+	// platform.
+	RenderMessage(message string) string
+	HashTags() string
+	DryRun() bool
+	EditStrategy() editStrategy
+	RemoveStrategy() removeStrategy
+	// ListWindow is how far back posts are listed and removed entries are
+	// withdrawn.
+	ListWindow() time.Duration
+}
+
+// newsThread is a news entry split into the posts of a thread.
+type newsThread struct {
+	entry newsEntry
+	posts []string
+	// postedIDs are the IDs of the first posts of a partially posted thread.
+	// Only the remaining posts are posted, as replies to the last of them.
+	postedIDs []string
+	// edit is set if an earlier version of the entry was posted.
+	edit *threadEdit
+}
+
+func (t newsThread) remaining() []string {
+	return t.posts[len(t.postedIDs):]
+}
+
+func (t newsThread) inReplyTo() string {
+	if len(t.postedIDs) == 0 {
+		return ""
+	}
+	return t.postedIDs[len(t.postedIDs)-1]
 }
 
 func run(
 	ctx context.Context,
 	news []newsEntry,
 	clients []postingClient,
+	postLedger ledger,
 ) error {
-	news = transformNewsEntries(news, trimSpace)
-	log.Printf("Found %d news entries total", len(news))
-	slices.SortFunc(news, func(a, b newsEntry) int {
-		return int(a.Time.UnixNano() - b.Time.UnixNano())
-	})
+	ids := newsIDs(news)
+	news = prepareNews(news)
 
 	for _, c := range clients {
-		log.Printf("Running %s client", c.PlatformName())
-
-		newsForClient := copySlice(news)
-		for name, filter := range c.NewsFilter() {
-			newsForClient = filterNewsEntries(newsForClient, filter)
-			log.Printf("%d news entries left after filter %q", len(newsForClient), name)
-		}
+		log.Printf("Running %s client for account %s", c.PlatformName(), c.Name())
 
 		posts, err := c.ListPosts(ctx)
 		if err != nil {
@@ -150,113 +107,213 @@ func run(
 		log.Printf("Found %d %s posts total", len(posts), c.PlatformName())
 
 		postFile, err := os.OpenFile(
-			fmt.Sprintf("%s.json", c.PlatformName()),
+			fmt.Sprintf("%s.json", c.Name()),
 			os.O_RDWR|os.O_CREATE|os.O_TRUNC,
 			0o644,
 		)
 		if err != nil {
-			return fmt.Errorf("opening %s.json: %w", c.PlatformName(), err)
+			return fmt.Errorf("opening %s.json: %w", c.Name(), err)
 		}
 		defer func() { _ = postFile.Close() }()
 		if err := json.NewEncoder(postFile).Encode(posts); err != nil {
 			return fmt.Errorf("encoding posts: %w", err)
 		}
-		log.Printf("Wrote posts file to %s.json", c.PlatformName())
+		log.Printf("Wrote posts file to %s.json", c.Name())
 
-		newsTextSplit := make([][]string, len(newsForClient))
-		for i, n := range newsForClient {
-			newsTextSplit[i] = splitIntoPosts(n.Message, c.MaxPostLen())
+		// Entries are matched to their posts first, so that the posts of an
+		// entry whose ID changed with an edit aren't withdrawn.
+		threads, matched := unpostedThreads(c, news, posts, postLedger)
+		if err := withdrawRemovedEntries(ctx, c, ids, matched, postLedger); err != nil {
+			return fmt.Errorf("withdrawing removed news entries: %w", err)
 		}
 
-		newsTextSplit = notYetPosted(newsTextSplit, posts)
-		if len(newsTextSplit) == 0 {
+		if len(threads) == 0 {
 			log.Println("No unposted news entries found")
 			continue
 		}
-		log.Printf("Found %d unposted news entries", len(newsTextSplit))
+		log.Printf("Found %d unposted news entries", len(threads))
 
-		if err := postNextNewsEntries(ctx, c, newsTextSplit); err != nil {
+		if err := postNextNewsEntries(ctx, c, postLedger, threads); err != nil {
 			return fmt.Errorf("posting next news entries: %w", err)
 		}
 	}
 	return nil
 }
 
+// prepareNews normalizes the news entries, drops those with false condition
+// and sorts them by time.
+func prepareNews(news []newsEntry) []newsEntry {
+	news = transformNewsEntries(news, formatNewsEntry)
+	log.Printf("Found %d news entries total", len(news))
+	news = filterNewsEntries(news, conditionMet)
+	log.Printf("%d news entries left after dropping entries with false condition", len(news))
+	slices.SortFunc(news, func(a, b newsEntry) int {
+		return int(a.Time.UnixNano() - b.Time.UnixNano())
+	})
+	return news
+}
+
+// unpostedThreads returns the threads of the news entries that pass the
+// filters of the client and are neither in the ledger nor in posts, and the
+// IDs of the posts matched to the entries, see notYetPosted.
+func unpostedThreads(c postingClient, news []newsEntry, posts []post, postLedger ledger) ([]newsThread, map[string]bool) {
+	newsForClient := copySlice(news)
+	for name, filter := range c.NewsFilter() {
+		newsForClient = filterNewsEntries(newsForClient, filter)
+		log.Printf("%d news entries left after filter %q", len(newsForClient), name)
+	}
+
+	threads := make([]newsThread, len(newsForClient))
+	for i, n := range newsForClient {
+		threads[i] = newsThread{entry: n, posts: clientPosts(c, n.Message, c.HashTags())}
+	}
+	// The posts of the entries left out by the filters can't be taken for
+	// earlier versions of the other entries.
+	kept := newsIDs(newsForClient)
+	var others []newsThread
+	for _, n := range news {
+		if !kept[n.ID] {
+			others = append(others, newsThread{entry: n, posts: clientPosts(c, n.Message, c.HashTags())})
+		}
+	}
+
+	threads = notInLedger(threads, postLedger, c.Name())
+	log.Printf("%d news entries left after consulting the ledger", len(threads))
+
+	threads, matched := notYetPosted(threads, others, posts)
+	if c.EditStrategy() == editStrategyNone {
+		threads = slices.DeleteFunc(threads, func(t newsThread) bool {
+			if t.edit != nil {
+				log.Printf("News entry %s was edited, ignoring", t.entry.ID)
+			}
+			return t.edit != nil
+		})
+	}
+	return threads, matched
+}
+
+// strictPolicy strips all HTML. Policies are safe for concurrent use.
+var strictPolicy = bluemonday.StrictPolicy()
+
+// listMarkerSpaceRegexp matches the list markers a message is rendered with.
+var listMarkerSpaceRegexp = regexp.MustCompile(`(^|\s)[-*•] `)
+
+// lineBreakRegexp matches the HTML line and paragraph breaks of posts.
+var lineBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*<p>`)
+
 func canonicalizePost(s string) string {
-	p := bluemonday.StrictPolicy()
+	p := strictPolicy
 	s = html.UnescapeString(s)
 	if su, err := strconv.Unquote(`"` + s + `"`); err == nil {
 		s = su
 	}
+	// Line breaks of posts are whitespace, like the newlines of the news.
+	s = lineBreakRegexp.ReplaceAllString(s, " ")
+	// Markup is stripped before sanitizing, which would take autolinks for
+	// tags, and again for the autolinks of posts that were escaped.
+	s = stripMarkup(s)
 	s = p.Sanitize(s)
 	s = html.UnescapeString(s)
+	s = stripMarkup(s)
 	s = p.Sanitize(s)
+	// Posts are the same with and without the links of option paths.
+	s = withoutOptionLinks(s)
 	s = strings.ReplaceAll(s, strings.TrimSpace(hashTags), "")
-	s = strings.TrimSpace(s)
+	s = listMarkerSpaceRegexp.ReplaceAllString(s, "$1- ")
+	s = flattenMessage(s)
 	return s
 }
 
-func postNextNewsEntries(ctx context.Context, client postingClient, newsTextSplit [][]string) error {
-	for i, posts := range newsTextSplit {
+func postNextNewsEntries(ctx context.Context, client postingClient, postLedger ledger, threads []newsThread) error {
+	for i, thread := range threads {
 		if i >= client.MaxPosts() {
 			break
 		}
 
-		log.Printf("Posting news entry %d with %d parts", i, len(posts))
-		for j, post := range posts {
-			log.Printf("  %d/%d: %s", j+1, len(posts), post)
+		if thread.edit != nil {
+			log.Printf("Updating edited news entry %d (%s)", i, client.EditStrategy())
+			record, err := updateNewsEntry(ctx, client, thread)
+			if err != nil {
+				return fmt.Errorf("updating news entry %d: %w", i, err)
+			}
+			if !client.DryRun() && thread.entry.ID != "" {
+				if err := postLedger.Record(client.Name(), record); err != nil {
+					return fmt.Errorf("recording news entry %d in ledger: %w", i, err)
+				}
+			}
+			continue
 		}
 
-		if err := client.CreatePostChain(ctx, posts); err != nil {
-			return fmt.Errorf("posting news entry %d: %w", i, err)
+		posts := thread.remaining()
+		if len(thread.postedIDs) > 0 {
+			log.Printf("Resuming news entry %d after %d of %d parts", i, len(thread.postedIDs), len(thread.posts))
+		} else {
+			log.Printf("Posting news entry %d with %d parts", i, len(posts))
+		}
+		for j, post := range posts {
+			log.Printf("  %d/%d: %s", len(thread.postedIDs)+j+1, len(thread.posts), post)
 		}
-	}
-
-	return nil
-}
 
-func splitIntoPosts(message string, maxPostLen int) []string {
-	if message == "" {
-		return nil
-	}
+		postIDs, postErr := client.CreatePostChain(ctx, posts, thread.inReplyTo())
+		postIDs = slices.Concat(thread.postedIDs, postIDs)
 
-	if len(message)+len(hashTags) <= maxPostLen {
-		return []string{message + hashTags}
-	}
+		var docIDs []string
+		if postErr == nil && thread.entry.ModuleDoc != "" {
+			lastID := ""
+			if len(postIDs) > 0 {
+				lastID = postIDs[len(postIDs)-1]
+			}
+			docIDs = postModuleDoc(ctx, client, thread.entry, lastID)
+		}
 
-	var posts []string
-	var post string
-	for _, word := range strings.Split(message, " ") {
-		var lenHashtags int
-		if len(posts) == 0 {
-			// First post get hash tags, so additional space needed.
-			lenHashtags = len(hashTags)
+		if !client.DryRun() && thread.entry.ID != "" && (postErr == nil || len(postIDs) > len(thread.postedIDs)) {
+			// A partially posted thread is recorded as well, so that the next
+			// run posts the missing parts.
+			if err := postLedger.Record(client.Name(), ledgerRecord{
+				EntryID:     thread.entry.ID,
+				PostedAt:    time.Now().UTC(),
+				PostIDs:     postIDs,
+				Parts:       len(thread.posts),
+				MessageHash: thread.entry.messageHash(),
+				UpdateIDs:   docIDs,
+			}); err != nil {
+				return errors.Join(postErr, fmt.Errorf("recording news entry %d in ledger: %w", i, err))
+			}
 		}
-		if len(post)+len(word)+1 > (maxPostLen - lenHashtags - 5) { // 5 for "[n/n]", 1 for space
-			posts = append(posts, post)
-			post = ""
+		if postErr != nil {
+			return fmt.Errorf("posting news entry %d: %w", i, postErr)
 		}
-		post += word + " "
-	}
-	posts = append(posts, post)
-
-	for i := range posts {
-		posts[i] = fmt.Sprintf("%s[%d/%d]", posts[i], i+1, len(posts))
 	}
-	posts[0] = posts[0] + hashTags
 
-	return posts
+	return nil
 }
 
+// Display modes of the Home Manager news file, see news.display.
+const (
+	newsDisplayNotify = "notify"
+	newsDisplayShow   = "show"
+	newsDisplaySilent = "silent"
+)
+
 type newsEntry struct {
-	Time    time.Time `json:"time"`
-	Message string    `json:"message"`
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Condition bool      `json:"condition"`
+	Message   string    `json:"message"`
+	// Display is the display mode of the news file the entry was read from.
+	Display string `json:"display,omitempty"`
+	// ModuleDoc is the documentation of the new module the entry announces,
+	// see attachModuleDocs. It is posted as a reply to the thread.
+	ModuleDoc string `json:"-"`
 }
 
 func (n *newsEntry) UnmarshalJSON(data []byte) error {
 	aux := &struct {
-		Time    string `json:"time"`
-		Message string `json:"message"`
+		ID        string `json:"id"`
+		Time      string `json:"time"`
+		Condition *bool  `json:"condition"`
+		Message   string `json:"message"`
+		Display   string `json:"display"`
 	}{}
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
@@ -267,19 +324,44 @@ func (n *newsEntry) UnmarshalJSON(data []byte) error {
 	} else {
 		n.Time = parsedTime
 	}
+	n.ID = aux.ID
+	// Entries without condition are always shown by Home Manager.
+	n.Condition = aux.Condition == nil || *aux.Condition
 	n.Message = aux.Message
+	n.Display = aux.Display
 	return nil
 }
 
 type newsFile struct {
+	Display string      `json:"display"`
 	Entries []newsEntry `json:"entries"`
 }
 
+func (f *newsFile) UnmarshalJSON(data []byte) error {
+	aux := &struct {
+		Display string      `json:"display"`
+		Entries []newsEntry `json:"entries"`
+	}{}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	switch aux.Display {
+	case "", newsDisplayNotify, newsDisplayShow, newsDisplaySilent:
+	default:
+		log.Printf("Warn: unknown news display mode %q", aux.Display)
+	}
+	f.Display = aux.Display
+	f.Entries = aux.Entries
+	for i := range f.Entries {
+		f.Entries[i].Display = f.Display
+	}
+	return nil
+}
+
 var spaceRegexp = regexp.MustCompile(`\s+`)
 
-func trimSpace(n newsEntry) newsEntry {
-	m := strings.TrimSpace(n.Message)
-	n.Message = spaceRegexp.ReplaceAllString(m, " ")
+func formatNewsEntry(n newsEntry) newsEntry {
+	n.Message = formatMessage(n.Message)
 	return n
 }
 
@@ -290,24 +372,177 @@ func transformNewsEntries(news []newsEntry, transform func(newsEntry) newsEntry)
 	return news
 }
 
-func inTimeWindow(n newsEntry) bool {
-	return n.Time.After(time.Now().AddDate(0, 0, -postWindow))
+func conditionMet(n newsEntry) bool {
+	return n.Condition
 }
 
-func notYetPosted(newsEntriesSplit [][]string, posts []post) [][]string {
-	var unposted [][]string
-newsLoop:
-	for _, news := range newsEntriesSplit {
-		for _, post := range posts {
-			if strings.Contains(canonicalizePost(post.Text()), canonicalizePost(news[0])) {
-				continue newsLoop
+// notInLedger drops the threads recorded in the ledger. Partially posted
+// threads are kept and continue after the recorded posts, threads of entries
+// edited since they were posted are kept as edits.
+func notInLedger(threads []newsThread, postLedger ledger, platform string) []newsThread {
+	var unposted []newsThread
+	for _, thread := range threads {
+		if thread.entry.ID != "" {
+			// Entries posted again after they were withdrawn are new.
+			if record, ok := postLedger.Lookup(platform, thread.entry.ID); ok && record.WithdrawnAt == nil {
+				if record.complete() {
+					if record.MessageHash == "" || record.MessageHash == thread.entry.messageHash() {
+						continue
+					}
+					thread.edit = &threadEdit{record: record}
+					unposted = append(unposted, thread)
+					continue
+				}
+				if record.Parts != len(thread.posts) {
+					log.Printf("Warn: news entry %s was partially posted with %d parts, but now has %d, not resuming",
+						thread.entry.ID, record.Parts, len(thread.posts))
+					continue
+				}
+				thread.postedIDs = record.PostIDs
 			}
 		}
-		unposted = append(unposted, news)
+		unposted = append(unposted, thread)
 	}
 	return unposted
 }
 
+// notYetPosted drops the threads that were already posted. Threads whose
+// posts end before their last [n/m] marker are kept and continue after the
+// last post found. Threads that weren't posted, but are similar to a posted
+// thread no other entry was found in, are kept as edits of that thread.
+// Posts found for the other threads, and posts created before an entry, aren't
+// taken as earlier versions of it. It also returns the IDs of the posts
+// matched to the threads, posted or not.
+func notYetPosted(threads, others []newsThread, posts []post) ([]newsThread, map[string]bool) {
+	index := newPostIndex(posts)
+	matched := make(map[string]bool)
+	match := func(chain []post) {
+		for _, p := range chain {
+			if p != nil && p.ID() != "" {
+				matched[p.ID()] = true
+			}
+		}
+	}
+
+	find := func(thread newsThread) (int, bool) {
+		if idx, ok := index.find(canonicalizePost(thread.posts[0])); ok {
+			return idx, true
+		}
+		// Posted with other parts, like before the splitter changed.
+		return index.findSplit(threadText(thread.posts))
+	}
+
+	// Posts found for an entry can't be the earlier version of another one.
+	found := make([]int, len(threads))
+	claimed := make(map[int]bool)
+	for _, thread := range others {
+		if len(thread.posts) == 0 {
+			continue
+		}
+		if idx, ok := find(thread); ok {
+			claimed[idx] = true
+		}
+	}
+	for i, thread := range threads {
+		found[i] = -1
+		if len(thread.postedIDs) > 0 || thread.edit != nil {
+			continue
+		}
+		if idx, ok := find(thread); ok {
+			found[i] = idx
+			claimed[idx] = true
+			match(index.thread(idx))
+		}
+	}
+
+	// edit returns the edit of the posted thread, or nil if an update with
+	// the message of the thread was already posted.
+	edit := func(thread newsThread, posted []post) *threadEdit {
+		edit := postedEdit(thread.entry.ID, posted)
+		for _, update := range index.updates(posted) {
+			match(update)
+			if isUpdateOf(update, thread.posts) {
+				log.Printf("News entry %s was edited, but its update was already posted", thread.entry.ID)
+				return nil
+			}
+			edit.record.UpdateIDs = append(edit.record.UpdateIDs, postIDs(update)...)
+		}
+		return edit
+	}
+
+	var unposted []newsThread
+	for i, thread := range threads {
+		switch {
+		case len(thread.postedIDs) > 0 || thread.edit != nil:
+			// Resumed or edited according to the ledger.
+		case found[i] >= 0:
+			chain := index.postedChain(found[i])
+			if len(chain) == 0 {
+				// Posted completely, but the parts after the first one may
+				// have been edited. Threads of posts without IDs can't be
+				// followed and are taken as posted.
+				if p := index.posts[found[i]]; p.hasMarker && p.n == 1 && p.m > 1 {
+					posted := index.thread(found[i])
+					if len(posted) == p.m && threadText(postTexts(posted)) != threadText(thread.posts) {
+						log.Printf("News entry %s differs from the thread of post %s after the first part, taking it as edited",
+							thread.entry.ID, p.ID())
+						if thread.edit = edit(thread, posted); thread.edit != nil {
+							break
+						}
+					}
+				}
+				continue
+			}
+			if len(chain) != len(thread.posts) {
+				log.Printf("Warn: news entry %s was partially posted with %d parts, but now has %d, not resuming",
+					thread.entry.ID, len(chain), len(thread.posts))
+				continue
+			}
+			for _, p := range chain {
+				if p == nil {
+					break
+				}
+				thread.postedIDs = append(thread.postedIDs, p.ID())
+			}
+		default:
+			idx, similarity, ok := index.similar(canonicalizePost(thread.posts[0]), func(j int) bool {
+				return claimed[j] || postedBefore(index.posts[j].post, thread.entry.Time)
+			})
+			if !ok || similarity < editSimilarity {
+				break
+			}
+			claimed[idx] = true
+			log.Printf("News entry %s is %.0f%% similar to post %s, taking it as edited", thread.entry.ID, similarity*100, index.posts[idx].ID())
+			match(index.thread(idx))
+			if thread.edit = edit(thread, index.thread(idx)); thread.edit == nil {
+				continue
+			}
+		}
+		unposted = append(unposted, thread)
+	}
+	return unposted, matched
+}
+
+// postedBefore reports whether the post is known to be created before t.
+func postedBefore(p post, t time.Time) bool {
+	cp, ok := p.(cachedPost)
+	return ok && !t.IsZero() && isBefore(cp.postedAt(), t)
+}
+
+var partMarkerRegexp = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+
+// partMarker returns the last [n/m] marker of a canonicalized post.
+func partMarker(canonical string) (n, m int, ok bool) {
+	matches := partMarkerRegexp.FindAllStringSubmatch(canonical, -1)
+	if len(matches) == 0 {
+		return 0, 0, false
+	}
+	last := matches[len(matches)-1]
+	n, _ = strconv.Atoi(last[1])
+	m, _ = strconv.Atoi(last[2])
+	return n, m, n > 0 && n <= m
+}
+
 func filterNewsEntries(news []newsEntry, filter func(newsEntry) bool) []newsEntry {
 	var filtered []newsEntry
 	for _, entry := range news {
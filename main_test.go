@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -89,7 +90,7 @@ func TestRun(t *testing.T) {
 			}
 			client.newsFilter = filter
 
-			assert.NoError(run(ctx, newsFile.Entries, []postingClient{client}))
+			assert.NoError(run(ctx, newsFile.Entries, []postingClient{client}, newMemLedger()))
 			assert.Len(client.createPostChainPosts, len(tc.wantPostsContains))
 			for i, want := range tc.wantPostsContains {
 				assert.Contains(client.createPostChainPosts[i].Text(), want, "post %d should contain %q", i, want)
@@ -99,11 +100,168 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunLedger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+
+	t.Cleanup(func() {
+		assert.NoError(os.Remove("stub.json"))
+	})
+
+	f, err := os.ReadFile("testdata/2025-05-15T21:37:58/news.json")
+	require.NoError(err)
+	newsFile := newsFile{}
+	require.NoError(json.Unmarshal(f, &newsFile))
+	f, err = os.ReadFile("testdata/2025-05-15T21:37:58/mastodon.json")
+	require.NoError(err)
+	var mastodonPosts []*mastodon.Status
+	require.NoError(json.Unmarshal(f, &mastodonPosts))
+
+	var kickoffID string
+	for _, n := range newsFile.Entries {
+		if strings.Contains(n.Message, "'programs.kickoff'") {
+			kickoffID = n.ID
+		}
+	}
+	require.NotEmpty(kickoffID)
+
+	postLedger := newMemLedger()
+	require.NoError(postLedger.Record("stub", ledgerRecord{EntryID: kickoffID}))
+
+	now := time.Date(2025, 5, 15, 21, 37, 58, 0, time.UTC)
+	client := stubPostingClientFromMastodonPosts(mastodonPosts)
+	client.newsFilter = map[string]func(newsEntry) bool{
+		"not older than 90d": func(n newsEntry) bool {
+			return n.Time.After(now.AddDate(0, 0, -postWindow))
+		},
+	}
+
+	require.NoError(run(ctx, newsFile.Entries, []postingClient{client}, postLedger))
+	require.Len(client.createPostChainPosts, 2)
+	assert.Contains(client.createPostChainPosts[0].Text(), "programs.mpvpaper")
+	assert.Contains(client.createPostChainPosts[1].Text(), "programs.visidata")
+
+	var mpvpaperID string
+	for _, n := range newsFile.Entries {
+		if strings.Contains(n.Message, "'programs.mpvpaper'") {
+			mpvpaperID = n.ID
+		}
+	}
+	record, ok := postLedger.Lookup("stub", mpvpaperID)
+	require.True(ok, "posted entry should be recorded")
+	assert.Equal([]string{"0"}, record.PostIDs)
+}
+
+// threeParts is a news entry that is split into three posts of at most 100
+// characters.
+func threeParts() newsEntry {
+	words := make([]string, 60)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%02d", i)
+	}
+	return newsEntry{ID: "three", Condition: true, Message: strings.Join(words, " ")}
+}
+
+func TestRunResumesFromLedger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		assert.NoError(os.Remove("stub.json"))
+	})
+
+	postLedger := newMemLedger()
+	client := &stubPostingClient{maxPostLen: 100, failAfter: 1}
+	require.Len(splitIntoPosts(threeParts().Message, hashTags, client.maxPostLen, client.PostLen), 3)
+
+	err := run(ctx, []newsEntry{threeParts()}, []postingClient{client}, postLedger)
+	require.Error(err)
+	record, ok := postLedger.Lookup("stub", "three")
+	require.True(ok, "partially posted entry should be recorded")
+	assert.Equal([]string{"0"}, record.PostIDs)
+	assert.Equal(3, record.Parts)
+
+	client.failAfter = 0
+	client.listPostsPosts = client.createPostChainPosts
+	require.NoError(run(ctx, []newsEntry{threeParts()}, []postingClient{client}, postLedger))
+	require.Len(client.createPostChainPosts, 3)
+	assert.Contains(client.createPostChainPosts[1].Text(), "[2/3]")
+	assert.Equal("0", client.createPostChainPosts[1].InReplyTo())
+	assert.Equal("1", client.createPostChainPosts[2].InReplyTo())
+	record, ok = postLedger.Lookup("stub", "three")
+	require.True(ok)
+	assert.Equal([]string{"0", "1", "2"}, record.PostIDs)
+
+	client.listPostsPosts = client.createPostChainPosts
+	require.NoError(run(ctx, []newsEntry{threeParts()}, []postingClient{client}, postLedger))
+	assert.Len(client.createPostChainPosts, 3, "complete thread shouldn't be posted again")
+}
+
+func TestNotYetPostedResumesFromMarkers(t *testing.T) {
+	entry := threeParts()
+	parts := splitIntoPosts(entry.Message, hashTags, 100, graphemeLen)
+	require.Len(t, parts, 3)
+	status := func(id, inReplyTo, content string) post {
+		s := &mastodon.Status{ID: mastodon.ID(id), Content: "<p>" + content + "</p>"}
+		if inReplyTo != "" {
+			s.InReplyToID = inReplyTo
+		}
+		return &mastodonPost{s}
+	}
+
+	testCases := map[string]struct {
+		posts         []post
+		parts         []string
+		wantPostedIDs []string
+		wantDropped   bool
+	}{
+		"not posted": {
+			posts: []post{status("a", "", "unrelated")},
+		},
+		"first part posted": {
+			posts:         []post{status("a", "", parts[0])},
+			wantPostedIDs: []string{"a"},
+		},
+		"two parts posted": {
+			posts:         []post{status("b", "a", parts[1]), status("a", "", parts[0])},
+			wantPostedIDs: []string{"a", "b"},
+		},
+		"second part not a reply": {
+			posts:         []post{status("b", "x", parts[1]), status("a", "", parts[0])},
+			wantPostedIDs: []string{"a"},
+		},
+		"complete": {
+			posts:       []post{status("c", "b", parts[2]), status("b", "a", parts[1]), status("a", "", parts[0])},
+			wantDropped: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			threads, _ := notYetPosted([]newsThread{{entry: entry, posts: parts}}, nil, tc.posts)
+			if tc.wantDropped {
+				assert.Empty(t, threads)
+				return
+			}
+			require.Len(t, threads, 1)
+			assert.Equal(t, tc.wantPostedIDs, threads[0].postedIDs)
+		})
+	}
+}
+
 type stubPostingClient struct {
 	maxPostLen           int
 	listPostsPosts       []post
 	createPostChainPosts []post
 	newsFilter           map[string]func(newsEntry) bool
+	// failAfter makes CreatePostChain fail once it created that many posts.
+	failAfter      int
+	editStrategy   editStrategy
+	removeStrategy removeStrategy
+	listWindow     time.Duration
+	// editedPosts are the texts of the posts edited with EditPost, by ID.
+	editedPosts  map[string]string
+	deletedPosts []string
 }
 
 func stubPostingClientFromMastodonPosts(posts []*mastodon.Status) *stubPostingClient {
@@ -121,23 +279,61 @@ func stubPostingClientFromBlueskyPosts(posts []*bsky.FeedPost) *stubPostingClien
 		maxPostLen: (&blueskyClient{}).MaxPostLen(),
 	}
 	for _, post := range posts {
-		stubClient.listPostsPosts = append(stubClient.listPostsPosts, &blueskyPost{post})
+		stubClient.listPostsPosts = append(stubClient.listPostsPosts, &blueskyPost{FeedPost: post})
 	}
 	return stubClient
 }
 
-func (c *stubPostingClient) CreatePostChain(_ context.Context, postChain []string) error {
+func (c *stubPostingClient) CreatePostChain(_ context.Context, postChain []string, inReplyTo string) ([]string, error) {
+	var ids []string
 	for _, post := range postChain {
-		c.createPostChainPosts = append(c.createPostChainPosts, &mastodonPost{&mastodon.Status{Content: post}})
+		if c.failAfter > 0 && len(c.createPostChainPosts) >= c.failAfter {
+			return ids, errors.New("stub failure")
+		}
+		id := strconv.Itoa(len(c.createPostChainPosts))
+		status := &mastodon.Status{ID: mastodon.ID(id), Content: post}
+		if inReplyTo != "" {
+			status.InReplyToID = inReplyTo
+		}
+		c.createPostChainPosts = append(c.createPostChainPosts, &mastodonPost{status})
+		ids = append(ids, id)
+		inReplyTo = id
+	}
+	return ids, nil
+}
+
+func (c *stubPostingClient) EditPost(_ context.Context, id, text string) error {
+	if c.editedPosts == nil {
+		c.editedPosts = make(map[string]string)
 	}
+	c.editedPosts[id] = text
+	return nil
+}
+
+func (c *stubPostingClient) DeletePost(_ context.Context, id string) error {
+	c.deletedPosts = append(c.deletedPosts, id)
 	return nil
 }
 
+func (c *stubPostingClient) PostLen(text string) int {
+	return graphemeLen(text)
+}
+
+func (c *stubPostingClient) RenderMessage(message string) string {
+	return renderLines(message, true)
+}
+
 func (c *stubPostingClient) ListPosts(context.Context) ([]post, error)   { return c.listPostsPosts, nil }
 func (c *stubPostingClient) NewsFilter() map[string]func(newsEntry) bool { return c.newsFilter }
+func (c *stubPostingClient) Name() string                                { return "stub" }
 func (c *stubPostingClient) PlatformName() string                        { return "stub" }
 func (c *stubPostingClient) MaxPosts() int                               { return 2 }
 func (c *stubPostingClient) MaxPostLen() int                             { return c.maxPostLen }
+func (c *stubPostingClient) HashTags() string                            { return hashTags }
+func (c *stubPostingClient) DryRun() bool                                { return false }
+func (c *stubPostingClient) EditStrategy() editStrategy                  { return c.editStrategy }
+func (c *stubPostingClient) RemoveStrategy() removeStrategy              { return c.removeStrategy }
+func (c *stubPostingClient) ListWindow() time.Duration                   { return c.listWindow }
 
 func TestCanonicalizePost(t *testing.T) {
 	/*
@@ -165,14 +361,25 @@ func TestCanonicalizePost(t *testing.T) {
 			post: "\u003cp\u003eThe option `programs.pay-respects.rules` was added. It generates runtime rule files at {file}`$XDG_CONFIG_HOME/pay-respects/rules/\u0026lt;name\u0026gt;.toml`, where each attribute name under `rules` becomes a filename (for example, `rules.cargo` writes `cargo.toml`). For the full runtime-rules format and command matching requirements, see \u0026lt;\u003ca href=\"https://github.com/iffse/pay-respects/blob/main/rules.md\" target=\"_blank\" rel=\"nofollow noopener\" translate=\"no\"\u003e\u003cspan class=\"invisible\"\u003ehttps://\u003c/span\u003e\u003cspan class=\"ellipsis\"\u003egithub.com/iffse/pay-respects/\u003c/span\u003e\u003cspan class=\"invisible\"\u003eblob/main/rules.md\u003c/span\u003e\u003c/a\u003e\u0026gt;.\u003cbr /\u003e\u003ca href=\"https://techhub.social/tags/NixOS\" class=\"mention hashtag\" rel=\"tag\"\u003e#\u003cspan\u003eNixOS\u003c/span\u003e\u003c/a\u003e \u003ca href=\"https://techhub.social/tags/Nix\" class=\"mention hashtag\" rel=\"tag\"\u003e#\u003cspan\u003eNix\u003c/span\u003e\u003c/a\u003e \u003ca href=\"https://techhub.social/tags/HomeManager\" class=\"mention hashtag\" rel=\"tag\"\u003e#\u003cspan\u003eHomeManager\u003c/span\u003e\u003c/a\u003e\u003c/p\u003e",
 			news: "The option `programs.pay-respects.rules` was added.\n\nIt generates runtime rule files at\n{file}`$XDG_CONFIG_HOME/pay-respects/rules/<name>.toml`, where each\nattribute name under `rules` becomes a filename (for example, `rules.cargo`\nwrites `cargo.toml`).\n\nFor the full runtime-rules format and command matching requirements, see\n<https://github.com/iffse/pay-respects/blob/main/rules.md>.\n",
 		},
+		{
+			// Posted on a single line, before paragraphs and lists were kept.
+			post: `<p>Two new modules are available: - &#39;programs.borgmatic&#39; and - &#39;services.borgmatic&#39;. use the first to configure the borgmatic tool and the second if you want to automatically run scheduled backups.<br /><a href="https://techhub.social/tags/NixOS" class="mention hashtag" rel="tag">#<span>NixOS</span></a> <a href="https://techhub.social/tags/Nix" class="mention hashtag" rel="tag">#<span>Nix</span></a> <a href="https://techhub.social/tags/HomeManager" class="mention hashtag" rel="tag">#<span>HomeManager</span></a></p>`,
+			news: "\nTwo new modules are available:\n\n  - 'programs.borgmatic' and\n  - 'services.borgmatic'.\n\nuse the first to configure the borgmatic tool and the second if you\nwant to automatically run scheduled backups.\n",
+		},
+		{
+			post: `<p>Two new modules are available:</p><p>• &#39;programs.borgmatic&#39; and<br />• &#39;services.borgmatic&#39;.</p><p>use the first to configure the borgmatic tool and the second if you want to automatically run scheduled backups.<br /><a href="https://techhub.social/tags/NixOS" class="mention hashtag" rel="tag">#<span>NixOS</span></a> <a href="https://techhub.social/tags/Nix" class="mention hashtag" rel="tag">#<span>Nix</span></a> <a href="https://techhub.social/tags/HomeManager" class="mention hashtag" rel="tag">#<span>HomeManager</span></a></p>`,
+			news: "\nTwo new modules are available:\n\n  - 'programs.borgmatic' and\n  - 'services.borgmatic'.\n\nuse the first to configure the borgmatic tool and the second if you\nwant to automatically run scheduled backups.\n",
+		},
 	}
 	for i, tc := range testCases {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			canonicalPost := canonicalizePost(tc.post)
-			canonicalNews := canonicalizePost(spaceRegexp.ReplaceAllString(strings.TrimSpace(tc.news), " "))
 			t.Logf("canonical post: %s\n", canonicalPost)
-			t.Logf("canonical news: %s\n", canonicalNews)
-			assert.True(t, strings.Contains(canonicalPost, canonicalNews), "canonical post should contain canonical news")
+			for _, keepCode := range []bool{false, true} {
+				canonicalNews := canonicalizePost(renderLines(formatMessage(tc.news), keepCode))
+				t.Logf("canonical news: %s\n", canonicalNews)
+				assert.True(t, strings.Contains(canonicalPost, canonicalNews), "canonical post should contain canonical news")
+			}
 		})
 	}
 }
@@ -222,7 +429,7 @@ Ut wisi enim ad minim veniam, quis nostrud exerci tation ullamcorper suscipit lo
 
 Nam liber tempor cum soluta nobis eleifend option congue nihil imperdiet doming id quod mazim placerat facer possim assum. Lorem ipsum dolor sit amet, consectetuer adipiscing elit, sed diam nonummy nibh euismod tincidunt ut laoreet dolore magna aliquam erat volutpat. Ut wisi enim ad minim veniam, quis nostrud`,
 			300,
-			8,
+			9,
 		},
 		{ // 2000 characters
 			`Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet.
@@ -233,7 +440,7 @@ Ut wisi enim ad minim veniam, quis nostrud exerci tation ullamcorper suscipit lo
 
 Nam liber tempor cum soluta nobis eleifend option congue nihil imperdiet doming id quod mazim placerat facer possim assum. Lorem ipsum dolor sit amet, consectetuer adipiscing elit, sed diam nonummy nibh euismod tincidunt ut laoreet dolore magna aliquam erat volutpat. Ut wisi enim ad minim v`,
 			300,
-			7,
+			8,
 		},
 		{ // 951 characters
 			`Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet. Lorem ipsum dolor sit amet, consetetur sadipscing elitr, sed diam nonumy eirmod tempor invidunt ut labore et dolore magna aliquyam erat, sed diam voluptua. At vero eos et accusam et justo duo dolores et ea rebum. Stet clita kasd gubergren, no sea takimata sanctus est Lorem ipsum dolor sit amet.
@@ -248,12 +455,12 @@ Duis autem vel eum iriure dolor in hendrerit in vulputate vel`,
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			assert := assert.New(t)
 
-			toots := splitIntoPosts(tc.message, tc.maxPostLen)
+			toots := splitIntoPosts(tc.message, hashTags, tc.maxPostLen, graphemeLen)
 			assert.Len(toots, tc.wantToots)
 
 			for _, toot := range toots {
 				fmt.Println(toot)
-				assert.LessOrEqual(len(toot), tc.maxPostLen)
+				assert.LessOrEqual(graphemeLen(toot), tc.maxPostLen)
 			}
 			assert.Contains(toots[0], hashTags)
 		})
@@ -270,4 +477,28 @@ func TestParseNewsFile(t *testing.T) {
 	news := newsFile{}
 	assert.NoError(json.Unmarshal(f, &news))
 	assert.Len(news.Entries, 233)
+	assert.Equal(newsDisplayNotify, news.Display)
+	for _, n := range news.Entries {
+		assert.Len(n.ID, 64, "entry IDs are sha256 sums")
+		assert.Equal(newsDisplayNotify, n.Display)
+	}
+	assert.Len(filterNewsEntries(news.Entries, conditionMet), 204)
+}
+
+func TestParseNewsEntryCondition(t *testing.T) {
+	testCases := map[string]struct {
+		json          string
+		wantCondition bool
+	}{
+		"true":    {`{"condition":true,"message":"a"}`, true},
+		"false":   {`{"condition":false,"message":"a"}`, false},
+		"missing": {`{"message":"a"}`, true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var n newsEntry
+			require.NoError(t, json.Unmarshal([]byte(tc.json), &n))
+			assert.Equal(t, tc.wantCondition, n.Condition)
+		})
+	}
 }
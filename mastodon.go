@@ -2,7 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-mastodon"
@@ -14,85 +21,195 @@ type mastodonClient struct {
 }
 
 type mastodonClientConfig struct {
-	dryRun     bool
-	maxPosts   int
-	newsFilter map[string]func(newsEntry) bool
+	clientOptions
+}
+
+var mastodonPlatform = platform{
+	name:         "mastodon",
+	editStrategy: editStrategyEdit,
+	settings: []platformSetting{
+		{name: "server"},
+		{name: "client_id"},
+		{name: "client_secret", secret: true},
+		{name: "access_token", secret: true},
+	},
+	newClient: func(_ context.Context, settings map[string]string, opts clientOptions) (postingClient, error) {
+		return newMastodonClient(&mastodon.Config{
+			Server:       settings["server"],
+			ClientID:     settings["client_id"],
+			ClientSecret: settings["client_secret"],
+			AccessToken:  settings["access_token"],
+		}, mastodonClientConfig{
+			clientOptions: opts,
+		}), nil
+	},
 }
 
 func newMastodonClient(mConfig *mastodon.Config, config mastodonClientConfig) *mastodonClient {
+	client := mastodon.NewClient(mConfig)
+	client.Transport = &idempotencyTransport{next: newRetryTransport(http.DefaultTransport)}
 	return &mastodonClient{
-		client:               mastodon.NewClient(mConfig),
+		client:               client,
 		mastodonClientConfig: config,
 	}
 }
 
+// idempotencyTransport sets an Idempotency-Key on new statuses, so Mastodon
+// doesn't post a status twice if the request is retried.
+type idempotencyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/api/v1/statuses") ||
+		req.Header.Get("Idempotency-Key") != "" || req.GetBody == nil {
+		return t.next.RoundTrip(req)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	defer func() { _ = body.Close() }()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(hash.Sum(nil)))
+	return t.next.RoundTrip(req)
+}
+
+// ListPosts lists the statuses of the account created within the list window.
+// With a post cache, only the statuses since the newest cached status are
+// requested.
 func (c *mastodonClient) ListPosts(ctx context.Context) ([]post, error) {
 	acc, err := c.client.GetAccountCurrentUser(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("getting current user: %w", err)
 	}
-	var allStatuses []*mastodon.Status
-	var pg mastodon.Pagination
+	cache := readPostCache[*mastodonPost](c.postCachePath)
+	cutoff := c.listCutoff()
+
+	var listed []*mastodonPost
+	pg := mastodon.Pagination{SinceID: mastodon.ID(cache.Since), Limit: 40}
 	for {
 		statuses, err := c.client.GetAccountStatuses(ctx, acc.ID, &pg)
 		if err != nil {
 			return nil, fmt.Errorf("getting account statuses: %w", err)
 		}
-		allStatuses = append(allStatuses, statuses...)
-		if pg.MaxID == "" || len(statuses) == 0 {
+		for _, status := range statuses {
+			listed = append(listed, &mastodonPost{status})
+		}
+		// Statuses are listed from newest to oldest.
+		if pg.MaxID == "" || len(statuses) == 0 || statuses[len(statuses)-1].CreatedAt.Before(cutoff) {
 			break
 		}
-		pg.MinID = ""
+		pg = mastodon.Pagination{MaxID: pg.MaxID, SinceID: mastodon.ID(cache.Since), Limit: 40}
 	}
+	log.Printf("Listed %d new statuses, %d statuses cached", len(listed), len(cache.Posts))
 
-	var allPosts []post
-	for _, status := range allStatuses {
-		allPosts = append(allPosts, &mastodonPost{status})
-	}
-
-	return allPosts, nil
+	cache.update(listed, cutoff)
+	cache.write(c.postCachePath)
+	return cache.posts(), nil
 }
 
-func (c *mastodonClient) CreatePostChain(ctx context.Context, postChain []string) error {
+func (c *mastodonClient) CreatePostChain(ctx context.Context, postChain []string, inReplyTo string) ([]string, error) {
 	if c.dryRun {
-		return nil
+		return nil, nil
 	}
-	var lastStatusID mastodon.ID
+	var statusIDs []string
+	lastStatusID := mastodon.ID(inReplyTo)
 	for _, post := range postChain {
 		status, err := c.client.PostStatus(ctx, &mastodon.Toot{
 			Status:      post,
 			InReplyToID: lastStatusID,
 		})
 		if err != nil {
-			return fmt.Errorf("posting status: %w", err)
+			return statusIDs, fmt.Errorf("posting status: %w", err)
 		}
 		lastStatusID = status.ID
+		statusIDs = append(statusIDs, string(status.ID))
 		time.Sleep(2 * time.Second)
 
 	}
+	return statusIDs, nil
+}
+
+func (c *mastodonClient) DeletePost(ctx context.Context, id string) error {
+	if c.dryRun {
+		return nil
+	}
+	var apiErr *mastodon.APIError
+	if err := c.client.DeleteStatus(ctx, mastodon.ID(id)); errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		log.Printf("Status %s was already deleted", id)
+	} else if err != nil {
+		return fmt.Errorf("deleting status: %w", err)
+	}
+	forgetCachedPost[*mastodonPost](c.postCachePath, id)
 	return nil
 }
 
-func (c *mastodonClient) NewsFilter() map[string]func(newsEntry) bool {
-	return c.newsFilter
+// EditPost implements postEditor.
+func (c *mastodonClient) EditPost(ctx context.Context, id, text string) error {
+	if c.dryRun {
+		return nil
+	}
+	status, err := c.client.UpdateStatus(ctx, &mastodon.Toot{Status: text}, mastodon.ID(id))
+	if err != nil {
+		return fmt.Errorf("updating status: %w", err)
+	}
+	refreshCachedPost(c.postCachePath, &mastodonPost{status})
+	return nil
 }
 
 func (c *mastodonClient) PlatformName() string {
 	return "mastodon"
 }
 
-func (c *mastodonClient) MaxPosts() int {
-	return c.maxPosts
-}
-
 func (c *mastodonClient) MaxPostLen() int {
 	return 1000
 }
 
+func (c *mastodonClient) PostLen(text string) int {
+	return mastodonLen(text)
+}
+
+func (c *mastodonClient) RenderMessage(message string) string {
+	return linkOptionPaths(renderLines(message, true), c.optionsSearch)
+}
+
+func (c *mastodonClient) VerifyAccount(ctx context.Context) (string, error) {
+	acc, err := c.client.GetAccountCurrentUser(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+	return "@" + acc.Acct, nil
+}
+
 type mastodonPost struct {
 	*mastodon.Status
 }
 
+func (p *mastodonPost) ID() string {
+	if p == nil || p.Status == nil {
+		return ""
+	}
+	return string(p.Status.ID)
+}
+
+func (p *mastodonPost) InReplyTo() string {
+	if p == nil || p.Status == nil {
+		return ""
+	}
+	// The API returns IDs as strings, as they don't fit in a float64.
+	id, _ := p.InReplyToID.(string)
+	return id
+}
+
+func (p *mastodonPost) postedAt() time.Time {
+	return p.CreatedAt
+}
+
 func (p *mastodonPost) Text() string {
 	if p == nil {
 		return ""
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMastodon serves the statuses of an account, newest first, in pages of
+// two, and edits them.
+type fakeMastodon struct {
+	statuses []*mastodon.Status
+	requests []string
+	edits    []string
+}
+
+func (m *fakeMastodon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutPrefix(r.URL.Path, "/api/v1/statuses/"); ok && r.Method == http.MethodPut {
+		i := slices.IndexFunc(m.statuses, func(s *mastodon.Status) bool { return string(s.ID) == id })
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		m.edits = append(m.edits, id)
+		edited := *m.statuses[i]
+		edited.Content = "<p>" + html.EscapeString(r.FormValue("status")) + "</p>"
+		m.statuses[i] = &edited
+		_ = json.NewEncoder(w).Encode(edited)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/v1/accounts/verify_credentials":
+		_ = json.NewEncoder(w).Encode(mastodon.Account{ID: "1", Acct: "bot"})
+	case "/api/v1/accounts/1/statuses":
+		m.requests = append(m.requests, r.URL.RawQuery)
+		maxID, _ := strconv.Atoi(r.URL.Query().Get("max_id"))
+		sinceID, _ := strconv.Atoi(r.URL.Query().Get("since_id"))
+		var page []*mastodon.Status
+		for _, s := range m.statuses {
+			id, _ := strconv.Atoi(string(s.ID))
+			if (maxID == 0 || id < maxID) && id > sinceID && len(page) < 2 {
+				page = append(page, s)
+			}
+		}
+		if len(page) > 0 {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?max_id=%s>; rel="next"`, r.URL.Path, page[len(page)-1].ID))
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestMastodonListPosts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	fake := &fakeMastodon{}
+	// Status n is ages[n-1] days old.
+	ages := []int{100, 50, 20, 10, 2, 1}
+	for i, age := range ages {
+		fake.statuses = append([]*mastodon.Status{{
+			ID:        mastodon.ID(strconv.Itoa(i + 1)),
+			CreatedAt: now.AddDate(0, 0, -age),
+		}}, fake.statuses...)
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newMastodonClient(&mastodon.Config{Server: server.URL}, mastodonClientConfig{clientOptions{
+		listWindow:    30 * 24 * time.Hour,
+		postCachePath: filepath.Join(t.TempDir(), "posts-mastodon.json"),
+	}})
+
+	posts, err := client.ListPosts(ctx)
+	require.NoError(err)
+	assert.Equal([]string{"6", "5", "4", "3"}, postIDs(posts), "posts outside the window should be dropped")
+	assert.Equal([]string{"limit=40", "limit=40&max_id=5", "limit=40&max_id=3"}, fake.requests,
+		"listing should stop at the window")
+
+	fake.requests = nil
+	fake.statuses = append([]*mastodon.Status{{ID: "7", CreatedAt: now}}, fake.statuses...)
+	posts, err = client.ListPosts(ctx)
+	require.NoError(err)
+	assert.Equal([]string{"7", "6", "5", "4", "3"}, postIDs(posts))
+	assert.Equal([]string{"limit=40&since_id=6", "limit=40&max_id=7&since_id=6"}, fake.requests,
+		"only new statuses should be listed")
+}
+
+func TestMastodonPostInReplyTo(t *testing.T) {
+	var status mastodon.Status
+	// Snowflake IDs exceed the precision of a float64.
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "114512345678901235", "in_reply_to_id": "114512345678901234"}`), &status))
+	assert.Equal(t, "114512345678901234", (&mastodonPost{&status}).InReplyTo())
+	assert.Empty(t, (&mastodonPost{&mastodon.Status{ID: "1"}}).InReplyTo())
+}
+
+func TestMastodonEditPostRefreshesCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		assert.NoError(os.Remove("mastodon.json"))
+	})
+
+	entry := threeParts()
+	fake := &fakeMastodon{statuses: []*mastodon.Status{{
+		ID:        "1",
+		CreatedAt: time.Now(),
+		Content:   "<p>" + entry.Message + "<br />#NixOS #Nix #HomeManager</p>",
+	}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := newMastodonClient(&mastodon.Config{Server: server.URL}, mastodonClientConfig{clientOptions{
+		name:          "mastodon",
+		maxPosts:      1,
+		hashTags:      hashTags,
+		onEdit:        editStrategyEdit,
+		listWindow:    30 * 24 * time.Hour,
+		postCachePath: filepath.Join(t.TempDir(), "posts-mastodon.json"),
+	}})
+
+	entry.Message = strings.Replace(entry.Message, "w30", "W30", 1)
+	for range 2 {
+		// Without the ledger, the edit is recognized by the cached posts.
+		require.NoError(run(ctx, []newsEntry{entry}, []postingClient{client}, newMemLedger()))
+	}
+	assert.Equal([]string{"1"}, fake.edits, "the edited status should be cached")
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// homeManagerNewsDir is where a Home Manager checkout keeps one .nix file per
+// news entry.
+const homeManagerNewsDir = "modules/misc/news"
+
+// readHomeManagerNews parses the news entries of the Home Manager checkout at
+// root. Conditions are evaluated for a minimal configuration on the given
+// system (e.g. "x86_64-linux"), like the news.json built by the run workflow.
+func readHomeManagerNews(root, system string) ([]newsEntry, error) {
+	dir := filepath.Join(root, homeManagerNewsDir)
+	var news []newsEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".nix" {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading news file: %w", err)
+		}
+		entry, err := parseNixNewsEntry(string(src), system)
+		if err != nil {
+			return fmt.Errorf("parsing news file %q: %w", path, err)
+		}
+		news = append(news, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading Home Manager news from %q: %w", dir, err)
+	}
+	return news, nil
+}
+
+// parseNixNewsEntry parses a single news entry file. Files are either an
+// attribute set or a function returning one:
+//
+//	{ config, ... }:
+//	{
+//	  time = "2025-04-27T03:17:07+00:00";
+//	  condition = config.programs.foo.enable;
+//	  message = ''
+//	    A new module is available: 'programs.foo'.
+//	  '';
+//	}
+func parseNixNewsEntry(src, system string) (newsEntry, error) {
+	tokens, err := tokenizeNix(src)
+	if err != nil {
+		return newsEntry{}, err
+	}
+	bindings, err := nixTopLevelBindings(tokens)
+	if err != nil {
+		return newsEntry{}, err
+	}
+
+	entry := newsEntry{
+		Condition: true,
+		Display:   newsDisplayNotify,
+	}
+
+	timeTokens, ok := bindings["time"]
+	if !ok {
+		return newsEntry{}, errors.New("missing time")
+	}
+	timeStr, err := nixStringValue(timeTokens)
+	if err != nil {
+		return newsEntry{}, fmt.Errorf("time: %w", err)
+	}
+	if entry.Time, err = time.Parse(time.RFC3339, timeStr); err != nil {
+		return newsEntry{}, fmt.Errorf("parsing time: %w", err)
+	}
+
+	messageTokens, ok := bindings["message"]
+	if !ok {
+		return newsEntry{}, errors.New("missing message")
+	}
+	if entry.Message, err = nixStringValue(messageTokens); err != nil {
+		return newsEntry{}, fmt.Errorf("message: %w", err)
+	}
+
+	if conditionTokens, ok := bindings["condition"]; ok {
+		condition, err := evalNixCondition(conditionTokens, system)
+		if err != nil {
+			log.Printf("Warn: evaluating condition of entry from %s: %v, assuming true", timeStr, err)
+		} else {
+			entry.Condition = condition
+		}
+	}
+
+	// Same default as Home Manager's news module.
+	sum := sha256.Sum256([]byte(entry.Message))
+	entry.ID = hex.EncodeToString(sum[:])
+
+	return entry, nil
+}
+
+type nixTokenKind int
+
+const (
+	nixIdent nixTokenKind = iota
+	nixString
+	nixPunct
+)
+
+type nixToken struct {
+	kind nixTokenKind
+	text string
+}
+
+var nixPunctuation = []string{"&&", "||", "==", "!=", "->", "//", "++", "<=", ">=", "..."}
+
+func tokenizeNix(src string) ([]nixToken, error) {
+	var tokens []nixToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+		case c == '"':
+			s, n, err := lexNixString(src[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, nixToken{nixString, s})
+			i += n + 1
+		case strings.HasPrefix(src[i:], "''"):
+			s, n, err := lexNixIndentedString(src[i+2:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, nixToken{nixString, s})
+			i += n + 2
+		case isNixIdentStart(c) || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(src) && isNixIdentChar(src[j]) {
+				j++
+			}
+			tokens = append(tokens, nixToken{nixIdent, src[i:j]})
+			i = j
+		default:
+			p := src[i : i+1]
+			for _, punct := range nixPunctuation {
+				if strings.HasPrefix(src[i:], punct) {
+					p = punct
+					break
+				}
+			}
+			tokens = append(tokens, nixToken{nixPunct, p})
+			i += len(p)
+		}
+	}
+	return tokens, nil
+}
+
+func isNixIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isNixIdentChar(c byte) bool {
+	return isNixIdentStart(c) || c >= '0' && c <= '9' || c == '\'' || c == '-'
+}
+
+// lexNixString lexes a double quoted string, starting after the opening quote.
+// It returns the string value and the number of bytes consumed, including the
+// closing quote. Interpolations are kept verbatim.
+func lexNixString(src string) (string, int, error) {
+	var sb strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '"':
+			return sb.String(), i + 1, nil
+		case src[i] == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(src[i])
+			}
+		case strings.HasPrefix(src[i:], "${"):
+			n, err := nixInterpolationLen(src[i:])
+			if err != nil {
+				return "", 0, err
+			}
+			sb.WriteString(src[i : i+n])
+			i += n - 1
+		default:
+			sb.WriteByte(src[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// indentedStringPart is a piece of an indented string. Only literal source
+// text is subject to indentation stripping, escapes and interpolations are not.
+type indentedStringPart struct {
+	text    string
+	literal bool
+}
+
+// lexNixIndentedString lexes an indented string, starting after the opening
+// quotes. It returns the string value after indentation stripping and the
+// number of bytes consumed, including the closing quotes.
+func lexNixIndentedString(src string) (string, int, error) {
+	i := 0
+	// Whitespace and newline following the opening quotes are ignored
+	// if there is no other text on the line.
+	if j := strings.IndexFunc(src, func(r rune) bool { return r != ' ' }); j >= 0 && src[j] == '\n' {
+		i = j + 1
+	}
+
+	var parts []indentedStringPart
+	addLiteral := func(s string) {
+		if n := len(parts); n > 0 && parts[n-1].literal {
+			parts[n-1].text += s
+			return
+		}
+		parts = append(parts, indentedStringPart{s, true})
+	}
+	for i < len(src) {
+		switch {
+		case strings.HasPrefix(src[i:], "'''"):
+			parts = append(parts, indentedStringPart{"''", false})
+			i += 3
+		case strings.HasPrefix(src[i:], "''$"):
+			parts = append(parts, indentedStringPart{"$", false})
+			i += 3
+		case strings.HasPrefix(src[i:], `''\`) && i+3 < len(src):
+			var s string
+			switch src[i+3] {
+			case 'n':
+				s = "\n"
+			case 'r':
+				s = "\r"
+			case 't':
+				s = "\t"
+			default:
+				s = string(src[i+3])
+			}
+			parts = append(parts, indentedStringPart{s, false})
+			i += 4
+		case strings.HasPrefix(src[i:], "''"):
+			return stripNixIndentation(parts), i + 2, nil
+		case strings.HasPrefix(src[i:], "${"):
+			n, err := nixInterpolationLen(src[i:])
+			if err != nil {
+				return "", 0, err
+			}
+			parts = append(parts, indentedStringPart{src[i : i+n], false})
+			i += n
+		default:
+			addLiteral(src[i : i+1])
+			i++
+		}
+	}
+	return "", 0, errors.New("unterminated indented string")
+}
+
+// stripNixIndentation removes the common indentation of all lines, the same
+// way Nix does for indented strings.
+func stripNixIndentation(parts []indentedStringPart) string {
+	minIndent := -1
+	atStartOfLine, curIndent := true, 0
+	endOfIndent := func() {
+		if atStartOfLine {
+			atStartOfLine = false
+			if minIndent < 0 || curIndent < minIndent {
+				minIndent = curIndent
+			}
+		}
+	}
+	for _, part := range parts {
+		if !part.literal {
+			endOfIndent()
+			continue
+		}
+		for _, c := range []byte(part.text) {
+			switch {
+			case atStartOfLine && c == ' ':
+				curIndent++
+			case atStartOfLine && c == '\n':
+				// Empty lines don't influence the indentation.
+				curIndent = 0
+			case atStartOfLine:
+				endOfIndent()
+			case c == '\n':
+				atStartOfLine, curIndent = true, 0
+			}
+		}
+	}
+	if minIndent < 0 {
+		minIndent = 0
+	}
+
+	var sb strings.Builder
+	atStartOfLine, dropped := true, 0
+	for n, part := range parts {
+		if !part.literal {
+			atStartOfLine, dropped = false, 0
+			sb.WriteString(part.text)
+			continue
+		}
+		var s strings.Builder
+		for _, c := range []byte(part.text) {
+			switch {
+			case atStartOfLine && c == ' ':
+				if dropped >= minIndent {
+					s.WriteByte(c)
+				}
+				dropped++
+			case atStartOfLine && c == '\n':
+				dropped = 0
+				s.WriteByte(c)
+			case atStartOfLine:
+				atStartOfLine, dropped = false, 0
+				s.WriteByte(c)
+			default:
+				s.WriteByte(c)
+				if c == '\n' {
+					atStartOfLine = true
+				}
+			}
+		}
+		text := s.String()
+		// Remove the last line if it consists only of spaces.
+		if n == len(parts)-1 {
+			if p := strings.LastIndexByte(text, '\n'); p >= 0 && strings.Trim(text[p+1:], " ") == "" {
+				text = text[:p+1]
+			}
+		}
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
+
+// nixInterpolationLen returns the length of the interpolation at the start
+// of src, including "${" and the closing brace.
+func nixInterpolationLen(src string) (int, error) {
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("unterminated interpolation")
+}
+
+// nixTopLevelBindings returns the tokens of the values bound in the attribute
+// set the file evaluates to, keyed by attribute name.
+func nixTopLevelBindings(tokens []nixToken) (map[string][]nixToken, error) {
+	// Skip a function header like "{ config, ... }:" or "args:".
+	if len(tokens) > 1 && tokens[0].kind == nixIdent && tokens[1].text == ":" {
+		tokens = tokens[2:]
+	} else if len(tokens) > 0 && tokens[0].text == "{" {
+		if end := nixMatchingBrace(tokens); end > 0 && end+1 < len(tokens) && tokens[end+1].text == ":" {
+			tokens = tokens[end+2:]
+		}
+	}
+	if len(tokens) == 0 || tokens[0].text != "{" {
+		return nil, errors.New("expected attribute set")
+	}
+	end := nixMatchingBrace(tokens)
+	if end < 0 {
+		return nil, errors.New("unterminated attribute set")
+	}
+	tokens = tokens[1:end]
+
+	bindings := make(map[string][]nixToken)
+	for len(tokens) > 0 {
+		eq := -1
+		for i, t := range tokens {
+			if t.text == "=" {
+				eq = i
+				break
+			}
+		}
+		if eq != 1 || tokens[0].kind != nixIdent {
+			return nil, fmt.Errorf("unsupported binding starting with %q", tokens[0].text)
+		}
+		name := tokens[0].text
+		depth, semi := 0, -1
+	valueLoop:
+		for i := eq + 1; i < len(tokens); i++ {
+			switch tokens[i].text {
+			case "{", "(", "[":
+				depth++
+			case "}", ")", "]":
+				depth--
+			case ";":
+				if depth == 0 {
+					semi = i
+					break valueLoop
+				}
+			}
+		}
+		if semi < 0 {
+			return nil, fmt.Errorf("missing semicolon after %q", name)
+		}
+		bindings[name] = tokens[eq+1 : semi]
+		tokens = tokens[semi+1:]
+	}
+	return bindings, nil
+}
+
+func nixMatchingBrace(tokens []nixToken) int {
+	depth := 0
+	for i, t := range tokens {
+		if t.kind != nixPunct {
+			continue
+		}
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func nixStringValue(tokens []nixToken) (string, error) {
+	if len(tokens) != 1 || tokens[0].kind != nixString {
+		return "", errors.New("expected a string literal")
+	}
+	return tokens[0].text, nil
+}
+
+// evalNixCondition evaluates the small subset of expressions used as news
+// conditions: boolean literals, platform checks, module enable options (which
+// are all disabled in a minimal configuration), and !, && and ||.
+func evalNixCondition(tokens []nixToken, system string) (bool, error) {
+	e := &nixConditionEval{tokens: tokens, system: system}
+	v, err := e.or()
+	if err != nil {
+		return false, err
+	}
+	if len(e.tokens) > 0 {
+		return false, fmt.Errorf("unexpected %q", e.tokens[0].text)
+	}
+	return v, nil
+}
+
+type nixConditionEval struct {
+	tokens []nixToken
+	system string
+}
+
+func (e *nixConditionEval) peek() string {
+	if len(e.tokens) == 0 {
+		return ""
+	}
+	return e.tokens[0].text
+}
+
+func (e *nixConditionEval) or() (bool, error) {
+	v, err := e.and()
+	for err == nil && e.peek() == "||" {
+		e.tokens = e.tokens[1:]
+		var w bool
+		w, err = e.and()
+		v = v || w
+	}
+	return v, err
+}
+
+func (e *nixConditionEval) and() (bool, error) {
+	v, err := e.unary()
+	for err == nil && e.peek() == "&&" {
+		e.tokens = e.tokens[1:]
+		var w bool
+		w, err = e.unary()
+		v = v && w
+	}
+	return v, err
+}
+
+func (e *nixConditionEval) unary() (bool, error) {
+	if len(e.tokens) == 0 {
+		return false, errors.New("unexpected end of expression")
+	}
+	t := e.tokens[0]
+	e.tokens = e.tokens[1:]
+	switch {
+	case t.text == "!":
+		v, err := e.unary()
+		return !v, err
+	case t.text == "(":
+		v, err := e.or()
+		if err != nil {
+			return false, err
+		}
+		if e.peek() != ")" {
+			return false, errors.New("missing closing parenthesis")
+		}
+		e.tokens = e.tokens[1:]
+		return v, nil
+	case t.kind == nixIdent:
+		path := []string{t.text}
+		for len(e.tokens) > 1 && e.tokens[0].text == "." && e.tokens[1].kind == nixIdent {
+			path = append(path, e.tokens[1].text)
+			e.tokens = e.tokens[2:]
+		}
+		return e.attrPath(path)
+	default:
+		return false, fmt.Errorf("unexpected %q", t.text)
+	}
+}
+
+func (e *nixConditionEval) attrPath(path []string) (bool, error) {
+	last := path[len(path)-1]
+	switch {
+	case len(path) == 1 && last == "true":
+		return true, nil
+	case len(path) == 1 && last == "false":
+		return false, nil
+	case len(path) > 1 && path[len(path)-2] == "hostPlatform":
+		arch, kernel, _ := strings.Cut(e.system, "-")
+		switch last {
+		case "isLinux":
+			return kernel == "linux", nil
+		case "isDarwin":
+			return kernel == "darwin", nil
+		case "isx86_64":
+			return arch == "x86_64", nil
+		case "isAarch64":
+			return arch == "aarch64", nil
+		}
+	case path[0] == "config" && last == "enable":
+		return false, nil
+	}
+	return false, fmt.Errorf("unsupported expression %q", strings.Join(path, "."))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHomeManagerNews(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	news, err := readHomeManagerNews("testdata/home-manager", "x86_64-linux")
+	require.NoError(err)
+	require.Len(news, 4)
+
+	f, err := os.ReadFile("testdata/2025-05-15T21:37:58/news.json")
+	require.NoError(err)
+	var jsonNews newsFile
+	require.NoError(json.Unmarshal(f, &jsonNews))
+	jsonEntries := make(map[string]newsEntry)
+	for _, n := range jsonNews.Entries {
+		jsonEntries[n.ID] = n
+	}
+
+	// Entries also present in the news.json must be parsed identically.
+	for _, n := range news[:3] {
+		want, ok := jsonEntries[n.ID]
+		if assert.True(ok, "entry from %s should have the same ID as in news.json", n.Time) {
+			assert.Equal(want.Message, n.Message)
+			assert.Equal(want.Condition, n.Condition)
+			assert.True(want.Time.Equal(n.Time))
+		}
+	}
+
+	last := news[3]
+	assert.Equal("The option 'services.screen-locker.lockCmd' now expands ${HOME}.\n  Write '' for two single quotes.\n", last.Message)
+	assert.False(last.Condition)
+	assert.Equal(time.Date(2025, 4, 30, 10, 0, 0, 0, time.UTC), last.Time.UTC())
+	assert.Equal(newsDisplayNotify, last.Display)
+}
+
+func TestLexNixIndentedString(t *testing.T) {
+	testCases := map[string]struct {
+		src  string
+		want string
+	}{
+		"common indentation": {
+			src:  "\n    a\n      b\n    c\n  ''",
+			want: "a\n  b\nc\n",
+		},
+		"empty lines are ignored": {
+			src:  "\n    a\n\n  \n    b\n  ''",
+			want: "a\n\n\nb\n",
+		},
+		"text on first line": {
+			src:  "a\n  b''",
+			want: "a\n  b",
+		},
+		"escapes": {
+			src:  "\n  '''x''$y''\\n''\\z\n''",
+			want: "''x$y\nz\n",
+		},
+		"escape ends indentation": {
+			src:  "\n    ''${a}\n  b\n''",
+			want: "  ${a}\nb\n",
+		},
+		"interpolation": {
+			src:  "\n  ${config.home.homeDirectory}/x\n''",
+			want: "${config.home.homeDirectory}/x\n",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, n, err := lexNixIndentedString(tc.src)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, len(tc.src), n)
+		})
+	}
+}
+
+func TestEvalNixCondition(t *testing.T) {
+	testCases := map[string]struct {
+		expr    string
+		system  string
+		want    bool
+		wantErr bool
+	}{
+		"true":            {expr: "true", want: true},
+		"false":           {expr: "false", want: false},
+		"linux on linux":  {expr: "pkgs.stdenv.hostPlatform.isLinux", system: "x86_64-linux", want: true},
+		"darwin on linux": {expr: "hostPlatform.isDarwin", system: "x86_64-linux", want: false},
+		"darwin":          {expr: "pkgs.stdenv.hostPlatform.isDarwin", system: "aarch64-darwin", want: true},
+		"module enabled":  {expr: "config.programs.foo.enable", want: false},
+		"negation":        {expr: "!config.programs.foo.enable", want: true},
+		"and or":          {expr: "(false || true) && !false", want: true},
+		"unsupported":     {expr: `lib.versionAtLeast config.home.stateVersion "25.05"`, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tokens, err := tokenizeNix(tc.expr)
+			require.NoError(t, err)
+			got, err := evalNixCondition(tokens, tc.system)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// defaultOptionsSearch is the options search option paths link to.
+	defaultOptionsSearch = "https://home-manager-options.extranix.com/?query={option}&release=master"
+	// optionPlaceholder is replaced by the option path in the URL of the
+	// options search.
+	optionPlaceholder = "{option}"
+)
+
+// optionNamespaces are the top-level namespaces of the Home Manager options.
+// Only paths in them are linked, so that domains and file names aren't.
+var optionNamespaces = []string{
+	"accounts", "dconf", "editorconfig", "fonts", "gtk", "home", "i18n",
+	"launchd", "manual", "news", "nix", "nixGL", "nixpkgs", "pam", "programs",
+	"qt", "services", "specialisation", "systemd", "targets", "wayland", "xdg",
+	"xfconf", "xresources", "xsession",
+}
+
+// optionPathRegexp matches an option path at the start of a text. Segments
+// may be attribute names or placeholders like <name>.
+var optionPathRegexp = regexp.MustCompile(`^(?:` + strings.Join(optionNamespaces, "|") + `)(?:\.(?:[A-Za-z_][\w-]*|<[\w-]+>))+`)
+
+// fileExtensions end file names, like home.nix, that look like option paths.
+var fileExtensions = []string{"conf", "ini", "json", "lua", "nix", "sh", "toml", "txt", "yaml", "yml"}
+
+// optionPath is an option path in a text.
+type optionPath struct {
+	start, end int
+	path       string
+}
+
+// optionPaths finds the option paths in the text. Paths start a word, after
+// opening punctuation, and are followed by the end of the word.
+func optionPaths(text string) []optionPath {
+	var paths []optionPath
+	for i := 0; i < len(text); {
+		if i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			if !unicode.IsSpace(prev) && !strings.ContainsRune("([{<\"'`“‘*", prev) {
+				_, size := utf8.DecodeRuneInString(text[i:])
+				i += size
+				continue
+			}
+		}
+		m := optionPathRegexp.FindString(text[i:])
+		if m == "" || (i+len(m) < len(text) && text[i+len(m)] == '/') ||
+			slices.Contains(fileExtensions, m[strings.LastIndexByte(m, '.')+1:]) {
+			i++
+			continue
+		}
+		paths = append(paths, optionPath{start: i, end: i + len(m), path: m})
+		i += len(m)
+	}
+	return paths
+}
+
+// optionSearchURL returns the URL of the options search for the path.
+func optionSearchURL(search, path string) string {
+	return strings.ReplaceAll(search, optionPlaceholder, url.QueryEscape(path))
+}
+
+// linkOptionPaths appends the URL of the options search to the first mention
+// of each option path, after its closing quote or backtick. Code blocks are
+// left as they are.
+func linkOptionPaths(text, search string) string {
+	if search == "" {
+		return text
+	}
+	var b strings.Builder
+	linked := make(map[string]bool)
+	inCode := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), codeFence) {
+			inCode = !inCode
+		}
+		last := 0
+		for _, p := range optionPaths(line) {
+			if inCode || linked[p.path] {
+				continue
+			}
+			linked[p.path] = true
+			end := p.end
+			if p.start > 0 && end < len(line) && line[p.start-1] == line[end] && strings.ContainsRune("\"'`", rune(line[end])) {
+				end++
+			}
+			b.WriteString(line[last:end])
+			b.WriteString(" (" + optionSearchURL(search, p.path) + ")")
+			last = end
+		}
+		b.WriteString(line[last:])
+	}
+	return b.String()
+}
+
+// optionLinkRegexp matches URLs in parentheses, like the links linkOptionPaths
+// appends to option paths.
+var optionLinkRegexp = regexp.MustCompile(`(^|\s)\((https?://[^\s()]+)\)`)
+
+// withoutOptionLinks removes the links to an options search from a sanitized
+// text, see linkOptionPaths. Other URLs in parentheses are kept. The search is
+// recognized by the option path in its URL, so that the links to a search
+// configured earlier are removed as well.
+func withoutOptionLinks(text string) string {
+	return optionLinkRegexp.ReplaceAllStringFunc(text, func(link string) string {
+		if isOptionSearchURL(html.UnescapeString(optionLinkRegexp.FindStringSubmatch(link)[2])) {
+			return ""
+		}
+		return link
+	})
+}
+
+// isOptionSearchURL reports whether the URL has an option path where an
+// options search has its optionPlaceholder: as a query value, a segment of
+// the path or the fragment.
+func isOptionSearchURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	candidates := append(strings.Split(u.Path, "/"), u.Fragment)
+	for _, values := range u.Query() {
+		candidates = append(candidates, values...)
+	}
+	return slices.ContainsFunc(candidates, func(s string) bool {
+		return s != "" && optionPathRegexp.FindString(s) == s
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testOptionsSearch is the options search of the tests.
+const testOptionsSearch = "https://search.example/?q={option}"
+
+func TestOptionPaths(t *testing.T) {
+	testCases := map[string]struct {
+		text string
+		want []string
+	}{
+		"quoted": {
+			text: "A new module is available: 'programs.kickoff'.",
+			want: []string{"programs.kickoff"},
+		},
+		"code spans and placeholders": {
+			text: "Use `services.vdirsyncer.enable` and {option}`accounts.email.accounts.<name>.thunderbird.settings`.",
+			want: []string{"services.vdirsyncer.enable", "accounts.email.accounts.<name>.thunderbird.settings"},
+		},
+		"end of sentence": {
+			text: "The option xdg.configFile has moved to home.file. Set nix.settings: true",
+			want: []string{"xdg.configFile", "home.file", "nix.settings"},
+		},
+		"not option paths": {
+			text: "Edit home.nix or nix.conf, see https://example.com/programs.foo, foo.programs.bar, example.com, home-manager.users.alice or home.file/foo.",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var paths []string
+			for _, p := range optionPaths(tc.text) {
+				assert.Equal(t, p.path, tc.text[p.start:p.end])
+				paths = append(paths, p.path)
+			}
+			assert.Equal(t, tc.want, paths)
+		})
+	}
+}
+
+func TestLinkOptionPaths(t *testing.T) {
+	testCases := map[string]struct {
+		text string
+		want string
+	}{
+		"quoted": {
+			text: "A new module is available: 'programs.kickoff'.",
+			want: "A new module is available: 'programs.kickoff' (https://search.example/?q=programs.kickoff).",
+		},
+		"first mention only": {
+			text: "Use `programs.foo.enable` to enable\n• programs.foo.enable, and programs.foo.<name>.bar",
+			want: "Use `programs.foo.enable` (https://search.example/?q=programs.foo.enable) to enable\n" +
+				"• programs.foo.enable, and programs.foo.<name>.bar (https://search.example/?q=programs.foo.%3Cname%3E.bar)",
+		},
+		"code blocks": {
+			text: "Set programs.foo:\n```nix\nprograms.foo.enable = true;\n```\nor programs.foo.enable.",
+			want: "Set programs.foo (https://search.example/?q=programs.foo):\n```nix\nprograms.foo.enable = true;\n```\n" +
+				"or programs.foo.enable (https://search.example/?q=programs.foo.enable).",
+		},
+		"no options search": {
+			text: "A new module is available: 'programs.kickoff'.",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			search := testOptionsSearch
+			if tc.want == "" {
+				search, tc.want = "", tc.text
+			}
+
+			linked := linkOptionPaths(tc.text, search)
+			assert.Equal(tc.want, linked)
+			assert.Equal(canonicalizePost(tc.text), canonicalizePost(linked), "links shouldn't change the canonical post")
+		})
+	}
+}
+
+func TestWithoutOptionLinks(t *testing.T) {
+	testCases := map[string]struct {
+		text string
+		want string
+	}{
+		"default search": {
+			text: "Enable programs.foo (https://home-manager-options.extranix.com/?query=programs.foo&amp;release=master).",
+			want: "Enable programs.foo.",
+		},
+		"search with placeholder in path": {
+			text: "Enable 'programs.foo.&lt;name&gt;' (https://search.example/option/programs.foo.%3Cname%3E).",
+			want: "Enable 'programs.foo.&lt;name&gt;'.",
+		},
+		"other links": {
+			text: "See the wiki (https://wiki.example/Home_Manager) and the module " +
+				"(https://github.com/nix-community/home-manager/blob/master/modules/programs/foo.nix).",
+		},
+		"link without option": {
+			text: "See programs.foo (https://search.example/?q=foo).",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.want == "" {
+				tc.want = tc.text
+			}
+			assert.Equal(t, tc.want, withoutOptionLinks(tc.text))
+		})
+	}
+}
+
+func TestLinkOptionPathsPostLen(t *testing.T) {
+	message := "A new module is available: 'programs.kickoff'. Configure it with programs.kickoff.settings, " +
+		"programs.kickoff.package and services.kickoff.enable. " + "Kickoff is a fast launcher for Wayland. "
+	for range 3 {
+		message += message
+	}
+	linked := linkOptionPaths(message, defaultOptionsSearch)
+	assert.Equal(t, graphemeLen(message)+4*len(" ()")+4*mastodonURLLen, mastodonLen(linked))
+
+	posts := splitIntoPosts(linked, hashTags, 500, mastodonLen)
+	for _, p := range posts {
+		assert.LessOrEqual(t, mastodonLen(p), 500)
+	}
+	assert.Greater(t, len(linked), 500*(len(posts)-1), "URLs should be counted as 23 characters")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxDocOptions is the number of options besides enable that the
+// documentation of a new module lists.
+const maxDocOptions = 3
+
+// docOptionPriority orders the options of a module by importance. Other
+// options follow in alphabetical order.
+var docOptionPriority = []string{"package", "settings", "extraConfig", "config"}
+
+// newModuleRegexp matches the announcements of new modules, like "A new
+// module is available: 'programs.foo'", and captures the module path.
+var newModuleRegexp = regexp.MustCompile("^A new module (?:is available: )?['`]([\\w.-]+)['`]")
+
+// optionsDoc is the options.json of the Home Manager documentation. It maps
+// the option paths to their documentation.
+type optionsDoc map[string]optionDoc
+
+type optionDoc struct {
+	Description docText `json:"description"`
+}
+
+// docText is Markdown, given as a string or, in older versions, as an object
+// with the text and its type.
+type docText string
+
+func (t *docText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = docText(text)
+		return nil
+	}
+	var typed struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	*t = docText(typed.Text)
+	return nil
+}
+
+func readOptionsDoc(path string) (optionsDoc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading options documentation: %w", err)
+	}
+	var doc optionsDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing options documentation %q: %w", path, err)
+	}
+	return doc, nil
+}
+
+// moduleDoc returns the documentation of the module as a message in the
+// format of formatMessage: the description of its enable option and of its
+// most important options. Options that aren't documented are left out, and
+// it returns "" if none is.
+func (d optionsDoc) moduleDoc(module string) string {
+	var options []string
+	for name := range d {
+		option, ok := strings.CutPrefix(name, module+".")
+		if ok && option != "enable" && !strings.ContainsAny(option, `."<*`) {
+			options = append(options, option)
+		}
+	}
+	slices.SortFunc(options, func(a, b string) int {
+		if ra, rb := docOptionRank(a), docOptionRank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(a, b)
+	})
+	options = slices.Insert(options[:min(len(options), maxDocOptions)], 0, "enable")
+
+	var items []string
+	for _, option := range options {
+		path := module + "." + option
+		description := firstParagraph(string(d[path].Description))
+		if description == "" {
+			continue
+		}
+		items = append(items, "- "+path+": "+description)
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return formatMessage("Options of the new module " + module + ":\n\n" + strings.Join(items, "\n"))
+}
+
+// docOptionRank returns the position of the option in docOptionPriority, or
+// the length of the list for other options.
+func docOptionRank(option string) int {
+	if i := slices.Index(docOptionPriority, option); i >= 0 {
+		return i
+	}
+	return len(docOptionPriority)
+}
+
+// firstParagraph returns the first paragraph of the Markdown text on a single
+// line.
+func firstParagraph(text string) string {
+	paragraph, _, _ := strings.Cut(strings.TrimSpace(text), "\n\n")
+	return flattenMessage(paragraph)
+}
+
+// attachModuleDocs sets the documentation of the new modules the entries
+// announce, see optionsDoc.moduleDoc.
+func attachModuleDocs(news []newsEntry, doc optionsDoc) []newsEntry {
+	return transformNewsEntries(news, func(n newsEntry) newsEntry {
+		m := newModuleRegexp.FindStringSubmatch(strings.TrimSpace(n.Message))
+		if m == nil {
+			return n
+		}
+		n.ModuleDoc = doc.moduleDoc(m[1])
+		if n.ModuleDoc == "" {
+			log.Printf("Warn: no documentation of new module %s in the options", m[1])
+		}
+		return n
+	})
+}
+
+// postModuleDoc replies to the thread of a news entry with the documentation
+// of the module it announces. Failing to post it isn't fatal, the entry
+// itself was posted; it returns the IDs of the posts created.
+func postModuleDoc(ctx context.Context, client postingClient, n newsEntry, inReplyTo string) []string {
+	posts := clientPosts(client, n.ModuleDoc, "")
+	log.Printf("Replying with the documentation of the new module in %d parts", len(posts))
+	ids, err := client.CreatePostChain(ctx, posts, inReplyTo)
+	if err != nil {
+		log.Printf("Warn: replying with the documentation of the new module: %v", err)
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleDoc(t *testing.T) {
+	doc, err := readOptionsDoc("testdata/options.json")
+	require.NoError(t, err)
+
+	testCases := map[string]string{
+		"programs.foo": "Options of the new module programs.foo:\n\n" +
+			"- programs.foo.enable: Whether to enable foo, a tool for fooing.\n" +
+			"- programs.foo.package: The foo package to use.\n" +
+			"- programs.foo.settings: Configuration written to {file}`$XDG_CONFIG_HOME/foo/config.toml`.\n" +
+			"- programs.foo.enableZshIntegration: Whether to enable Zsh integration.",
+		"services.bar":          "Options of the new module services.bar:\n\n- services.bar.package: The bar package to use.",
+		"programs.undocumented": "",
+		"programs.missing":      "",
+	}
+
+	for module, want := range testCases {
+		t.Run(module, func(t *testing.T) {
+			assert.Equal(t, want, doc.moduleDoc(module))
+		})
+	}
+}
+
+func TestReadOptionsDocErrors(t *testing.T) {
+	_, err := readOptionsDoc("testdata/missing.json")
+	assert.Error(t, err)
+	_, err = readOptionsDoc("testdata/home-manager/modules/misc/news.nix")
+	assert.Error(t, err)
+}
+
+func TestAttachModuleDocs(t *testing.T) {
+	doc := optionsDoc{
+		"programs.foo.enable": {Description: "Whether to enable foo."},
+		"services.bar.enable": {Description: "Whether to enable bar."},
+	}
+	news := attachModuleDocs([]newsEntry{
+		{ID: "quotes", Message: "A new module is available: 'programs.foo'.\n\nFoo fooes."},
+		{ID: "backticks", Message: "\nA new module is available: `services.bar`."},
+		{ID: "other order", Message: "A new module `programs.foo` is available."},
+		{ID: "undocumented", Message: "A new module is available: 'programs.baz'."},
+		{ID: "no announcement", Message: "The module 'programs.foo' was changed."},
+	}, doc)
+
+	docs := make(map[string]string)
+	for _, n := range news {
+		docs[n.ID] = n.ModuleDoc
+	}
+	assert.Equal(t, map[string]string{
+		"quotes":          "Options of the new module programs.foo:\n\n- programs.foo.enable: Whether to enable foo.",
+		"backticks":       "Options of the new module services.bar:\n\n- services.bar.enable: Whether to enable bar.",
+		"other order":     "Options of the new module programs.foo:\n\n- programs.foo.enable: Whether to enable foo.",
+		"undocumented":    "",
+		"no announcement": "",
+	}, docs)
+}
+
+func TestRunPostsModuleDoc(t *testing.T) {
+	entry := newsEntry{
+		ID:        "foo",
+		Condition: true,
+		Message:   "A new module is available: 'programs.foo'.",
+		ModuleDoc: "Options of the new module programs.foo:\n\n- programs.foo.enable: Whether to enable foo.",
+	}
+
+	testCases := map[string]struct {
+		failAfter     int
+		wantUpdateIDs []string
+	}{
+		"reply":        {wantUpdateIDs: []string{"1"}},
+		"failed reply": {failAfter: 1},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			t.Cleanup(func() {
+				assert.NoError(os.Remove("stub.json"))
+			})
+
+			postLedger := newMemLedger()
+			client := &stubPostingClient{maxPostLen: 500, failAfter: tc.failAfter}
+			require.NoError(run(context.Background(), []newsEntry{entry}, []postingClient{client}, postLedger))
+
+			require.Len(client.createPostChainPosts, 1+len(tc.wantUpdateIDs))
+			if tc.wantUpdateIDs != nil {
+				reply := client.createPostChainPosts[1]
+				assert.Equal("0", reply.InReplyTo(), "documentation should reply to the thread")
+				assert.Contains(reply.Text(), "• programs.foo.enable: Whether to enable foo.")
+			}
+			record, ok := postLedger.Lookup("stub", "foo")
+			require.True(ok)
+			assert.Equal([]string{"0"}, record.PostIDs)
+			assert.Equal(tc.wantUpdateIDs, record.UpdateIDs)
+			assert.True(record.complete())
+		})
+	}
+}
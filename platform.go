@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// platform is a posting platform the bot can be configured for.
+type platform struct {
+	name string
+	// settings of the platform. An account of the platform must set all of
+	// them, except for the optional ones.
+	settings []platformSetting
+	// editStrategy is the default for accounts of the platform.
+	editStrategy editStrategy
+	// newClient creates a client from the values of the settings.
+	newClient func(ctx context.Context, settings map[string]string, opts clientOptions) (postingClient, error)
+}
+
+type platformSetting struct {
+	// name of the setting in the config file. For the default account of
+	// the platform, it is read from HMNB_<PLATFORM>_<NAME>.
+	name string
+	// secret settings can also be read from a file given as <name>_file
+	// (HMNB_<PLATFORM>_<NAME>_FILE) or from the systemd credential
+	// <account>.<name>.
+	secret bool
+	// optional settings may be left empty.
+	optional bool
+}
+
+// clientOptions are the platform independent options of a client.
+type clientOptions struct {
+	name       string
+	dryRun     bool
+	maxPosts   int
+	hashTags   string
+	newsFilter map[string]func(newsEntry) bool
+	onEdit     editStrategy
+	onRemove   removeStrategy
+	// listWindow is how far back posts are listed.
+	listWindow time.Duration
+	// postCachePath is where the listed posts are cached. Caching is
+	// disabled if empty.
+	postCachePath string
+	// optionsSearch is the URL option paths in posts link to, with
+	// optionPlaceholder for the path. Option paths aren't linked if empty.
+	optionsSearch string
+}
+
+func (o clientOptions) Name() string                                { return o.name }
+func (o clientOptions) DryRun() bool                                { return o.dryRun }
+func (o clientOptions) MaxPosts() int                               { return o.maxPosts }
+func (o clientOptions) HashTags() string                            { return o.hashTags }
+func (o clientOptions) NewsFilter() map[string]func(newsEntry) bool { return o.newsFilter }
+func (o clientOptions) EditStrategy() editStrategy                  { return o.onEdit }
+func (o clientOptions) RemoveStrategy() removeStrategy              { return o.onRemove }
+func (o clientOptions) ListWindow() time.Duration                   { return o.listWindow }
+
+// listCutoff is the time before which posts are not listed.
+func (o clientOptions) listCutoff() time.Time {
+	if o.listWindow == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-o.listWindow)
+}
+
+// platforms is the registry of all supported platforms.
+var platforms = []platform{
+	mastodonPlatform,
+	blueskyPlatform,
+}
+
+func platformByName(name string) (platform, bool) {
+	for _, p := range platforms {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return platform{}, false
+}
+
+func (p platform) envName(setting platformSetting) string {
+	return fmt.Sprintf("HMNB_%s_%s", strings.ToUpper(p.name), strings.ToUpper(setting.name))
+}
+
+// enabledClients creates a client for each configured account.
+func enabledClients(ctx context.Context, cfg *config) ([]postingClient, error) {
+	for _, p := range platforms {
+		if !slices.ContainsFunc(cfg.Accounts, func(acc accountConfig) bool { return acc.Platform == p.name }) {
+			log.Printf("Platform %s not configured, skipping", p.name)
+		}
+	}
+
+	var clients []postingClient
+	for _, acc := range cfg.Accounts {
+		client, err := newAccountClient(ctx, cfg, acc)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Account %s on %s enabled", acc.Name, acc.Platform)
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// newAccountClient creates the client of a single account.
+func newAccountClient(ctx context.Context, cfg *config, acc accountConfig) (postingClient, error) {
+	p, ok := platformByName(acc.Platform)
+	if !ok {
+		return nil, fmt.Errorf("account %q: unknown platform %q", acc.Name, acc.Platform)
+	}
+	var missing []string
+	for _, setting := range p.settings {
+		if acc.Settings[setting.name] != "" || setting.optional {
+			continue
+		}
+		if acc.Name == p.name {
+			missing = append(missing, p.envName(setting))
+		} else {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("account %q is partially configured, missing %s", acc.Name, strings.Join(missing, ", "))
+	}
+
+	opts := cfg.clientOptions(acc)
+	client, err := p.newClient(ctx, acc.Settings, opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s client for account %q: %w", p.name, acc.Name, err)
+	}
+	if _, ok := client.(postEditor); !ok && opts.onEdit == editStrategyEdit {
+		return nil, fmt.Errorf("account %q: %s posts can't be edited, set onEdit to %q or %q", acc.Name, p.name, editStrategyReply, editStrategyNone)
+	}
+	return client, nil
+}
+
+// accountVerifier is implemented by clients that can check their credentials
+// against the platform.
+type accountVerifier interface {
+	// VerifyAccount returns the account as it is known to the platform.
+	VerifyAccount(ctx context.Context) (string, error)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnabledClients(t *testing.T) {
+	maxPosts := 2
+	mastodonSettings := map[string]string{
+		"server":        "https://techhub.social/",
+		"client_id":     "id",
+		"client_secret": "secret",
+		"access_token":  "token",
+	}
+
+	testCases := map[string]struct {
+		accounts     []accountConfig
+		wantAccounts []string
+		wantErr      bool
+	}{
+		"nothing configured": {},
+		"mastodon only": {
+			accounts: []accountConfig{
+				{Name: "mastodon", Platform: "mastodon", Settings: mastodonSettings},
+			},
+			wantAccounts: []string{"mastodon"},
+		},
+		"multiple accounts": {
+			accounts: []accountConfig{
+				{Name: "main", Platform: "mastodon", Settings: mastodonSettings},
+				{Name: "regional", Platform: "mastodon", Settings: mastodonSettings},
+			},
+			wantAccounts: []string{"main", "regional"},
+		},
+		"partially configured": {
+			accounts: []accountConfig{
+				{Name: "mastodon", Platform: "mastodon", Settings: map[string]string{"server": "https://techhub.social/"}},
+			},
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config{MaxPosts: &maxPosts, Accounts: tc.accounts}
+			clients, err := enabledClients(context.Background(), cfg)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var gotAccounts []string
+			for _, c := range clients {
+				gotAccounts = append(gotAccounts, c.Name())
+				assert.Equal(t, "mastodon", c.PlatformName())
+				assert.Equal(t, maxPosts, c.MaxPosts())
+			}
+			assert.Equal(t, tc.wantAccounts, gotAccounts)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// listMargin is added to the post window of an account when listing posts,
+// as a safety margin.
+const listMargin = 7 * 24 * time.Hour
+
+// cachedPost is a post that can be kept in a postCache.
+type cachedPost interface {
+	post
+	postedAt() time.Time
+}
+
+// postCache keeps the listed posts of an account between runs, so that a run
+// only lists the posts created since the last one. Posts deleted on the
+// platform stay in the cache until they leave the window; removing the cache
+// file forces a full listing.
+type postCache[P cachedPost] struct {
+	// Since is the ID of the newest listed post.
+	Since string `json:"since"`
+	// Posts are ordered from newest to oldest.
+	Posts []P `json:"posts"`
+}
+
+// readPostCache reads the cache at path. An empty cache is returned if there
+// is none, or if path is empty.
+func readPostCache[P cachedPost](path string) *postCache[P] {
+	c := &postCache[P]{}
+	if path == "" {
+		return c
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c
+	} else if err != nil {
+		log.Printf("Warn: reading post cache: %v", err)
+		return c
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		log.Printf("Warn: parsing post cache %q, listing all posts: %v", path, err)
+		return &postCache[P]{}
+	}
+	return c
+}
+
+// contains reports whether the post with the given ID is cached.
+func (c *postCache[P]) contains(id string) bool {
+	for _, p := range c.Posts {
+		if p.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// update adds the newly listed posts, ordered from newest to oldest, and
+// drops the posts created before cutoff. Posts without a known creation time
+// are kept.
+func (c *postCache[P]) update(listed []P, cutoff time.Time) {
+	var posts []P
+	seen := make(map[string]bool)
+	for _, p := range slices.Concat(listed, c.Posts) {
+		if seen[p.ID()] || isBefore(p.postedAt(), cutoff) {
+			continue
+		}
+		seen[p.ID()] = true
+		posts = append(posts, p)
+	}
+	if len(listed) > 0 {
+		c.Since = listed[0].ID()
+	}
+	c.Posts = posts
+}
+
+// isBefore reports whether t is known and before cutoff.
+func isBefore(t, cutoff time.Time) bool {
+	return !t.IsZero() && t.Before(cutoff)
+}
+
+// remove drops the post with the given ID and reports whether it was cached.
+func (c *postCache[P]) remove(id string) bool {
+	n := len(c.Posts)
+	c.Posts = slices.DeleteFunc(c.Posts, func(p P) bool { return p.ID() == id })
+	return len(c.Posts) < n
+}
+
+// forgetCachedPost removes a deleted post from the cache at path.
+func forgetCachedPost[P cachedPost](path, id string) {
+	cache := readPostCache[P](path)
+	if cache.remove(id) {
+		cache.write(path)
+	}
+}
+
+// replace replaces the cached post with the same ID as p and reports whether
+// there was one.
+func (c *postCache[P]) replace(p P) bool {
+	i := slices.IndexFunc(c.Posts, func(cached P) bool { return cached.ID() == p.ID() })
+	if i < 0 {
+		return false
+	}
+	c.Posts[i] = p
+	return true
+}
+
+// refreshCachedPost replaces an edited post in the cache at path, as edits
+// aren't listed again.
+func refreshCachedPost[P cachedPost](path string, p P) {
+	cache := readPostCache[P](path)
+	if cache.replace(p) {
+		cache.write(path)
+	}
+}
+
+// posts returns the cached posts.
+func (c *postCache[P]) posts() []post {
+	posts := make([]post, len(c.Posts))
+	for i, p := range c.Posts {
+		posts[i] = p
+	}
+	return posts
+}
+
+// write stores the cache at path. Failures only disable caching, so they are
+// logged and otherwise ignored.
+func (c *postCache[P]) write(path string) {
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("Warn: marshaling post cache: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Printf("Warn: creating post cache directory: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Printf("Warn: writing post cache: %v", err)
+	}
+}
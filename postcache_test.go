@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/mattn/go-mastodon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostCacheUpdate(t *testing.T) {
+	now := time.Date(2025, 5, 15, 12, 0, 0, 0, time.UTC)
+	status := func(id string, age time.Duration, text string) *mastodonPost {
+		return &mastodonPost{&mastodon.Status{ID: mastodon.ID(id), CreatedAt: now.Add(-age), Content: text}}
+	}
+
+	testCases := map[string]struct {
+		cached    []*mastodonPost
+		since     string
+		listed    []*mastodonPost
+		wantIDs   []string
+		wantSince string
+	}{
+		"empty cache": {
+			listed:    []*mastodonPost{status("3", time.Hour, ""), status("2", 2*time.Hour, "")},
+			wantIDs:   []string{"3", "2"},
+			wantSince: "3",
+		},
+		"newer posts are prepended": {
+			cached:    []*mastodonPost{status("2", 2*time.Hour, ""), status("1", 3*time.Hour, "")},
+			since:     "2",
+			listed:    []*mastodonPost{status("3", time.Hour, "")},
+			wantIDs:   []string{"3", "2", "1"},
+			wantSince: "3",
+		},
+		"nothing new": {
+			cached:    []*mastodonPost{status("2", 2*time.Hour, "")},
+			since:     "2",
+			wantIDs:   []string{"2"},
+			wantSince: "2",
+		},
+		"posts outside the window are dropped": {
+			cached:    []*mastodonPost{status("2", 2*time.Hour, ""), status("1", 48*time.Hour, "")},
+			since:     "2",
+			listed:    []*mastodonPost{status("3", time.Hour, "")},
+			wantIDs:   []string{"3", "2"},
+			wantSince: "3",
+		},
+		"listed posts replace cached ones": {
+			cached:    []*mastodonPost{status("2", 2*time.Hour, "old")},
+			since:     "2",
+			listed:    []*mastodonPost{status("3", time.Hour, ""), status("2", 2*time.Hour, "new")},
+			wantIDs:   []string{"3", "2"},
+			wantSince: "3",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			cache := &postCache[*mastodonPost]{Since: tc.since, Posts: tc.cached}
+			cache.update(tc.listed, now.Add(-24*time.Hour))
+			assert.Equal(tc.wantIDs, postIDs(cache.posts()))
+			assert.Equal(tc.wantSince, cache.Since)
+			for _, p := range cache.posts() {
+				assert.NotEqual("old", p.Text())
+			}
+		})
+	}
+}
+
+func TestPostCacheReadWrite(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "cache", "posts-bluesky.json")
+
+	assert.Empty(readPostCache[*blueskyPost](path).Posts, "missing cache should be empty")
+
+	cache := &postCache[*blueskyPost]{}
+	cache.update([]*blueskyPost{{
+		FeedPost: &bsky.FeedPost{Text: "hello", CreatedAt: "2025-05-15T12:00:00.000Z"},
+		uri:      "at://did:plc:bot/app.bsky.feed.post/1",
+		cid:      "cid1",
+	}}, time.Time{})
+	cache.write(path)
+
+	read := readPostCache[*blueskyPost](path)
+	require.Len(read.Posts, 1)
+	assert.Equal("at://did:plc:bot/app.bsky.feed.post/1", read.Since)
+	assert.Equal("at://did:plc:bot/app.bsky.feed.post/1", read.Posts[0].ID())
+	assert.Equal("cid1", read.Posts[0].cid)
+	assert.Equal("hello", read.Posts[0].Text())
+	assert.Equal(time.Date(2025, 5, 15, 12, 0, 0, 0, time.UTC), read.Posts[0].postedAt())
+	assert.True(read.contains("at://did:plc:bot/app.bsky.feed.post/1"))
+	assert.False(read.contains("at://did:plc:bot/app.bsky.feed.post/2"))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// listItemRegexp matches the first line of a list item in the news format,
+// which may be indented.
+var listItemRegexp = regexp.MustCompile(`^\s*([-*])\s+`)
+
+// codeFence starts and ends the code blocks of a message.
+const codeFence = "```"
+
+// formatMessage normalizes the whitespace of a news message, but keeps its
+// structure: paragraphs are separated by an empty line, and list items start
+// a line with their marker, "-" or "*". The hard-wrapped lines of paragraphs
+// and list items are joined, the lines of code blocks are kept.
+func formatMessage(message string) string {
+	var blocks [][]string
+	var block []string
+	var inList, inCode bool
+	flush := func() {
+		if len(block) > 0 {
+			blocks = append(blocks, block)
+		}
+		block, inList = nil, false
+	}
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), codeFence) {
+			if !inCode {
+				flush()
+			}
+			block = append(block, strings.TrimSpace(line))
+			if inCode {
+				flush()
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			block = append(block, strings.TrimRight(line, " \t"))
+			continue
+		}
+
+		var item, marker string
+		if m := listItemRegexp.FindStringSubmatch(line); m != nil {
+			item, marker = m[0], m[1]
+		}
+		line = spaceRegexp.ReplaceAllString(strings.TrimSpace(line[len(item):]), " ")
+		switch {
+		case item == "" && line == "":
+			flush()
+		case item != "":
+			if !inList {
+				flush()
+				inList = true
+			}
+			block = append(block, marker+" "+line)
+		case len(block) == 0:
+			block = append(block, line)
+		default:
+			// Continues the paragraph or the list item.
+			block[len(block)-1] += " " + line
+		}
+	}
+	flush()
+
+	paragraphs := make([]string, len(blocks))
+	for i, block := range blocks {
+		paragraphs[i] = strings.Join(block, "\n")
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// flattenMessage puts a message on a single line.
+func flattenMessage(message string) string {
+	return spaceRegexp.ReplaceAllString(strings.TrimSpace(message), " ")
+}
+
+// bulletRegexp matches the markers of the list items of a formatted message.
+var bulletRegexp = regexp.MustCompile(`^[-*] `)
+
+// renderLines renders a message formatted by formatMessage for platforms
+// that render newlines. List items get bullets, and the markup of the
+// documentation is replaced by plain text, see renderInline. With keepCode,
+// code spans and blocks keep their backticks.
+func renderLines(message string, keepCode bool) string {
+	lines := strings.Split(message, "\n")
+	rendered := lines[:0]
+	var inCode bool
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, codeFence):
+			inCode = !inCode
+			if !keepCode {
+				continue
+			}
+		case inCode:
+		default:
+			line = renderInline(bulletRegexp.ReplaceAllString(line, "• "), keepCode)
+		}
+		rendered = append(rendered, line)
+	}
+	return strings.Join(rendered, "\n")
+}
+
+var (
+	// roleRegexp matches the MyST role of a code span, like {file}.
+	roleRegexp = regexp.MustCompile("^\\{[\\w-]+\\}`")
+	// autolinkRegexp matches a URL in angle brackets.
+	autolinkRegexp = regexp.MustCompile(`^<(https?://[^\s<>]+)>`)
+	// linkRegexp matches a Markdown link.
+	linkRegexp = regexp.MustCompile(`^\[([^\]]+)\]\((https?://[^\s()]+)\)`)
+)
+
+// renderInline replaces the inline markup of the documentation: MyST roles
+// are dropped, autolinks and links become plain URLs, and strong emphasis
+// loses its asterisks. With keepCode, code spans keep their backticks.
+func renderInline(text string, keepCode bool) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		if m := roleRegexp.FindStringIndex(rest); m != nil {
+			// Continue with the code span.
+			i += m[1] - 1
+			continue
+		}
+		if m := autolinkRegexp.FindStringSubmatch(rest); m != nil {
+			b.WriteString(m[1])
+			i += len(m[0])
+			continue
+		}
+		if m := linkRegexp.FindStringSubmatch(rest); m != nil {
+			b.WriteString(m[1] + " (" + m[2] + ")")
+			i += len(m[0])
+			continue
+		}
+		if strings.HasPrefix(rest, "**") {
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				b.WriteString(renderInline(rest[2:2+end], keepCode))
+				i += end + 4
+				continue
+			}
+		}
+		if rest[0] == '`' {
+			end := codeSpanEnd(text, i)
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end > i+n && !keepCode {
+				b.WriteString(strings.TrimSpace(text[i+n : end-n]))
+			} else {
+				b.WriteString(text[i:end])
+			}
+			i = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(rest[:size])
+		i += size
+	}
+	return b.String()
+}
+
+var (
+	// markupRoleRegexp matches MyST roles anywhere in a post.
+	markupRoleRegexp = regexp.MustCompile("\\{[\\w-]+\\}`")
+	// markupAutolinkRegexp matches autolinks anywhere in a post.
+	markupAutolinkRegexp = regexp.MustCompile(`<(https?://[^\s<>]+)>`)
+	// markupLinkRegexp matches Markdown links anywhere in a post.
+	markupLinkRegexp = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s()]+)\)`)
+	// markupCodeRegexp matches the backticks of code spans and blocks.
+	markupCodeRegexp = regexp.MustCompile("```[\\w-]*|`")
+)
+
+// stripMarkup removes the markup renderLines renders differently per
+// platform, so that raw and rendered messages compare equal.
+func stripMarkup(s string) string {
+	s = markupRoleRegexp.ReplaceAllString(s, "`")
+	s = markupAutolinkRegexp.ReplaceAllString(s, "$1")
+	s = markupLinkRegexp.ReplaceAllString(s, "$1 ($2)")
+	s = strings.ReplaceAll(s, "**", "")
+	return markupCodeRegexp.ReplaceAllString(s, "")
+}
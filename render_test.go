@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMessage(t *testing.T) {
+	testCases := map[string]struct {
+		message string
+		want    string
+	}{
+		"hard-wrapped paragraphs": {
+			message: "\nThe khal and vdirsyncer modules make use of this new account\ninfrastructure.\n\n\nNote, these module are still   somewhat experimental.\n",
+			want:    "The khal and vdirsyncer modules make use of this new account infrastructure.\n\nNote, these module are still somewhat experimental.",
+		},
+		"indented list": {
+			message: "\nA number of new modules are available:\n\n  - 'accounts.calendar',\n  - 'accounts.contact', and\n  - 'services.vdirsyncer' (Linux only).\n\nThe two first modules offer a number of options.\n",
+			want:    "A number of new modules are available:\n\n- 'accounts.calendar',\n- 'accounts.contact', and\n- 'services.vdirsyncer' (Linux only).\n\nThe two first modules offer a number of options.",
+		},
+		"list after paragraph": {
+			message: "For example, you can now use:\n- `qt.platformTheme = \"kde\"`: set a theme using Plasma. You can\nconfigure it by setting `~/.config/kdeglobals` file;\n* `qt.style.name = \"kvantum\"`: override the style.\n",
+			want:    "For example, you can now use:\n\n- `qt.platformTheme = \"kde\"`: set a theme using Plasma. You can configure it by setting `~/.config/kdeglobals` file;\n* `qt.style.name = \"kvantum\"`: override the style.",
+		},
+		"single line": {
+			message: "  A new module is available: 'programs.foo'.  ",
+			want:    "A new module is available: 'programs.foo'.",
+		},
+		"code block": {
+			message: "Other modules will have to be enabled manually, like this:\n\n```nix\n  imports = [\n\n    \"${modulesPath}/programs/fzf.nix\"\n  ];  \n  ```\nThis entrypoint is only recommended\nfor advanced users.\n",
+			want:    "Other modules will have to be enabled manually, like this:\n\n```nix\n  imports = [\n\n    \"${modulesPath}/programs/fzf.nix\"\n  ];\n```\n\nThis entrypoint is only recommended for advanced users.",
+		},
+		"options with dashes": {
+			message: "Set 'programs.foo.extraArgs' to\n-v to get verbose output.",
+			want:    "Set 'programs.foo.extraArgs' to -v to get verbose output.",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			formatted := formatMessage(tc.message)
+			assert.Equal(tc.want, formatted)
+			assert.Equal(flattenMessage(tc.message), flattenMessage(formatted), "formatting should only change whitespace")
+		})
+	}
+}
+
+func TestRenderLines(t *testing.T) {
+	testCases := map[string]struct {
+		message   string
+		wantPlain string
+		wantCode  string
+	}{
+		"bullets": {
+			message:   "Two new modules are available:\n\n- 'programs.borgmatic' and\n* 'services.borgmatic'.\n\nUse the first - or the second.",
+			wantPlain: "Two new modules are available:\n\n• 'programs.borgmatic' and\n• 'services.borgmatic'.\n\nUse the first - or the second.",
+			wantCode:  "Two new modules are available:\n\n• 'programs.borgmatic' and\n• 'services.borgmatic'.\n\nUse the first - or the second.",
+		},
+		"roles": {
+			message:   "The 'defaultEditor' option now sets both {env}`EDITOR` and {env}`VISUAL`.",
+			wantPlain: "The 'defaultEditor' option now sets both EDITOR and VISUAL.",
+			wantCode:  "The 'defaultEditor' option now sets both `EDITOR` and `VISUAL`.",
+		},
+		"code spans": {
+			message:   "It generates files at {file}`$XDG_CONFIG_HOME/pay-respects/rules/<name>.toml`, where ``rules.`cargo` `` writes `cargo.toml`.",
+			wantPlain: "It generates files at $XDG_CONFIG_HOME/pay-respects/rules/<name>.toml, where rules.`cargo` writes cargo.toml.",
+			wantCode:  "It generates files at `$XDG_CONFIG_HOME/pay-respects/rules/<name>.toml`, where ``rules.`cargo` `` writes `cargo.toml`.",
+		},
+		"unclosed code span": {
+			message:   "Set programs.google-chrome.plasmaSupport = true` to enable it.",
+			wantPlain: "Set programs.google-chrome.plasmaSupport = true` to enable it.",
+			wantCode:  "Set programs.google-chrome.plasmaSupport = true` to enable it.",
+		},
+		"links": {
+			message:   "See <https://github.com/iffse/pay-respects/blob/main/rules.md> and the [manual](https://nix-community.github.io/home-manager/).",
+			wantPlain: "See https://github.com/iffse/pay-respects/blob/main/rules.md and the manual (https://nix-community.github.io/home-manager/).",
+			wantCode:  "See https://github.com/iffse/pay-respects/blob/main/rules.md and the manual (https://nix-community.github.io/home-manager/).",
+		},
+		"strong emphasis": {
+			message:   "This enables the \"Use QT\" theme in **Settings > Appearance**, and the '*' glob.",
+			wantPlain: "This enables the \"Use QT\" theme in Settings > Appearance, and the '*' glob.",
+			wantCode:  "This enables the \"Use QT\" theme in Settings > Appearance, and the '*' glob.",
+		},
+		"code block": {
+			message:   "Enable them manually:\n\n```nix\n  imports = [\n    \"${modulesPath}/programs/fzf.nix\"\n  ];\n```\n\n- `home-manager.minimal`",
+			wantPlain: "Enable them manually:\n\n  imports = [\n    \"${modulesPath}/programs/fzf.nix\"\n  ];\n\n• home-manager.minimal",
+			wantCode:  "Enable them manually:\n\n```nix\n  imports = [\n    \"${modulesPath}/programs/fzf.nix\"\n  ];\n```\n\n• `home-manager.minimal`",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			plain := renderLines(tc.message, false)
+			assert.Equal(tc.wantPlain, plain)
+			code := renderLines(tc.message, true)
+			assert.Equal(tc.wantCode, code)
+			assert.Equal(canonicalizePost(tc.message), canonicalizePost(plain), "rendering should keep the canonical text")
+			assert.Equal(canonicalizePost(tc.message), canonicalizePost(code), "rendering should keep the canonical text")
+		})
+	}
+}
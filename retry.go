@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// retryTransport retries requests that failed transiently and waits for
+// the rate limits announced by the platforms.
+//
+// Network errors and 5xx responses are only retried if the request can be
+// repeated without side effects: it has an idempotent method or an
+// Idempotency-Key header. Errors while connecting and 429 responses are
+// always retried, the server didn't process the request in both cases.
+type retryTransport struct {
+	next        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	// maxWait is the longest wait for a rate limit to reset. Requests
+	// limited for longer fail instead.
+	maxWait time.Duration
+	// sleep waits for d or until ctx is done.
+	sleep func(ctx context.Context, d time.Duration) error
+	now   func() time.Time
+
+	mux sync.Mutex
+	// notBefore is when the rate limit of a host resets, after the host
+	// reported that no requests remain.
+	notBefore map[string]time.Time
+}
+
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		next:        next,
+		maxAttempts: 5,
+		baseDelay:   time.Second,
+		maxDelay:    30 * time.Second,
+		maxWait:     5 * time.Minute,
+		sleep:       sleepContext,
+		now:         time.Now,
+		notBefore:   make(map[string]time.Time),
+	}
+}
+
+// newRetryClient returns an HTTP client retrying with the default transport.
+func newRetryClient() *http.Client {
+	return &http.Client{Transport: newRetryTransport(http.DefaultTransport)}
+}
+
+// errRateLimited is returned if a request is still rate limited after all
+// attempts or would have to wait longer than the maximum wait.
+var errRateLimited = errors.New("rate limited")
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 1; ; attempt++ {
+		if err := t.waitForRateLimit(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewinding request body: %w", err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if resp != nil {
+			t.recordRateLimit(req.URL.Host, resp)
+		}
+
+		reason, retry := t.shouldRetry(req, resp, err)
+		if !retry || !replayable {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			if reset, ok := rateLimitReset(resp.Header, t.now()); ok {
+				delay = max(reset.Sub(t.now()), 0)
+			}
+		}
+		if attempt >= t.maxAttempts || delay > t.maxWait {
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				drainAndClose(resp)
+				return nil, fmt.Errorf("%s %s: %w after %d attempts", req.Method, req.URL.Redacted(), errRateLimited, attempt)
+			}
+			return resp, err
+		}
+		if resp != nil {
+			drainAndClose(resp)
+		}
+
+		log.Printf("Warn: %s %s: %s, retrying in %s (attempt %d of %d)",
+			req.Method, req.URL.Redacted(), reason, delay.Round(time.Millisecond), attempt+1, t.maxAttempts)
+		if err := t.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry classifies the result of a request.
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return "", false
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return err.Error(), true
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return err.Error(), isIdempotent(req)
+		}
+		return "", false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return resp.Status, true
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status, isIdempotent(req)
+	}
+	return "", false
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff is the exponential delay before the next attempt, with jitter so
+// that clients don't retry in lockstep.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.baseDelay << (attempt - 1)
+	if d > t.maxDelay || d <= 0 {
+		d = t.maxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func (t *retryTransport) waitForRateLimit(ctx context.Context, host string) error {
+	t.mux.Lock()
+	notBefore := t.notBefore[host]
+	t.mux.Unlock()
+
+	wait := notBefore.Sub(t.now())
+	if wait <= 0 {
+		return nil
+	}
+	if wait > t.maxWait {
+		return fmt.Errorf("%s: %w until %s", host, errRateLimited, notBefore.Format(time.RFC3339))
+	}
+	log.Printf("Rate limit of %s exhausted, waiting %s", host, wait.Round(time.Second))
+	return t.sleep(ctx, wait)
+}
+
+func (t *retryTransport) recordRateLimit(host string, resp *http.Response) {
+	remaining, ok := rateLimitRemaining(resp.Header)
+	if !ok || remaining > 0 {
+		return
+	}
+	reset, ok := rateLimitReset(resp.Header, t.now())
+	if !ok {
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.notBefore[host] = reset
+}
+
+// rateLimitRemaining returns the number of requests left in the current
+// rate limit window, from the X-RateLimit-Remaining header of Mastodon or the
+// RateLimit-Remaining header of Bluesky.
+func rateLimitRemaining(h http.Header) (int, bool) {
+	for _, key := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+		if v := h.Get(key); v != "" {
+			remaining, err := strconv.Atoi(v)
+			return remaining, err == nil
+		}
+	}
+	return 0, false
+}
+
+// rateLimitReset returns when the rate limit resets. Mastodon sends the time
+// in X-RateLimit-Reset, Bluesky the Unix time in RateLimit-Reset, and both may
+// send Retry-After.
+func rateLimitReset(h http.Header, now time.Time) (time.Time, bool) {
+	if v := h.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(seconds) * time.Second), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t, true
+		}
+	}
+	if v := h.Get("X-RateLimit-Reset"); v != "" {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	if v := h.Get("RateLimit-Reset"); v != "" {
+		if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(unix, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func drainAndClose(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
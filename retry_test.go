@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRetryTransport returns a transport that records its waits instead of
+// sleeping.
+func testRetryTransport(now time.Time) (*retryTransport, *[]time.Duration) {
+	var waits []time.Duration
+	transport := newRetryTransport(http.DefaultTransport)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return transport, &waits
+}
+
+func TestRetryTransport(t *testing.T) {
+	now := time.Date(2025, 5, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		method     string
+		header     http.Header
+		responses  []func(w http.ResponseWriter)
+		wantStatus int
+		wantErr    error
+		wantCalls  int
+		wantWaits  []time.Duration
+	}{
+		"success": {
+			method:     http.MethodGet,
+			responses:  []func(http.ResponseWriter){status(http.StatusOK)},
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		"bad gateway is retried": {
+			method:     http.MethodGet,
+			responses:  []func(http.ResponseWriter){status(http.StatusBadGateway), status(http.StatusBadGateway), status(http.StatusOK)},
+			wantStatus: http.StatusOK,
+			wantCalls:  3,
+		},
+		"client errors aren't retried": {
+			method:     http.MethodGet,
+			responses:  []func(http.ResponseWriter){status(http.StatusNotFound)},
+			wantStatus: http.StatusNotFound,
+			wantCalls:  1,
+		},
+		"post isn't retried on bad gateway": {
+			method:     http.MethodPost,
+			responses:  []func(http.ResponseWriter){status(http.StatusBadGateway)},
+			wantStatus: http.StatusBadGateway,
+			wantCalls:  1,
+		},
+		"post with idempotency key is retried": {
+			method:     http.MethodPost,
+			header:     http.Header{"Idempotency-Key": {"key"}},
+			responses:  []func(http.ResponseWriter){status(http.StatusBadGateway), status(http.StatusOK)},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		"gives up after max attempts": {
+			method:     http.MethodGet,
+			responses:  []func(http.ResponseWriter){status(http.StatusServiceUnavailable)},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  5,
+		},
+		"mastodon rate limit": {
+			method: http.MethodPost,
+			responses: []func(http.ResponseWriter){
+				withHeader(status(http.StatusTooManyRequests), "X-RateLimit-Reset", now.Add(90*time.Second).Format(time.RFC3339Nano)),
+				status(http.StatusOK),
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantWaits:  []time.Duration{90 * time.Second},
+		},
+		"bluesky rate limit": {
+			method: http.MethodGet,
+			responses: []func(http.ResponseWriter){
+				withHeader(status(http.StatusTooManyRequests), "RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10)),
+				status(http.StatusOK),
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantWaits:  []time.Duration{time.Minute},
+		},
+		"retry after": {
+			method: http.MethodGet,
+			responses: []func(http.ResponseWriter){
+				withHeader(status(http.StatusTooManyRequests), "Retry-After", "7"),
+				status(http.StatusOK),
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantWaits:  []time.Duration{7 * time.Second},
+		},
+		"rate limit resets too late": {
+			method: http.MethodGet,
+			responses: []func(http.ResponseWriter){
+				withHeader(status(http.StatusTooManyRequests), "Retry-After", "3600"),
+			},
+			wantErr:   errRateLimited,
+			wantCalls: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var calls int
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				tc.responses[min(calls, len(tc.responses)-1)](w)
+				calls++
+			}))
+			defer server.Close()
+
+			transport, waits := testRetryTransport(now)
+			req, err := http.NewRequestWithContext(context.Background(), tc.method, server.URL, strings.NewReader("status=hello"))
+			require.NoError(err)
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			if tc.wantErr != nil {
+				assert.ErrorIs(err, tc.wantErr)
+			} else {
+				require.NoError(err)
+				defer func() { _ = resp.Body.Close() }()
+				assert.Equal(tc.wantStatus, resp.StatusCode)
+			}
+			assert.Equal(tc.wantCalls, calls)
+			for _, body := range bodies {
+				assert.Equal("status=hello", body, "body should be sent again on retry")
+			}
+			if tc.wantWaits != nil {
+				assert.Equal(tc.wantWaits, *waits)
+			}
+			assert.Len(*waits, calls-1)
+		})
+	}
+}
+
+func TestRetryTransportExhaustedRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	now := time.Date(2025, 5, 15, 12, 0, 0, 0, time.UTC)
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+	}))
+	defer server.Close()
+
+	transport, waits := testRetryTransport(now)
+	client := &http.Client{Transport: transport}
+	for range 2 {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		require.NoError(err)
+		resp, err := client.Do(req)
+		require.NoError(err)
+		assert.NoError(resp.Body.Close())
+	}
+	assert.Equal(2, calls)
+	assert.Equal([]time.Duration{30 * time.Second}, *waits, "second request should wait for the reset")
+}
+
+func TestRetryTransportContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := newRetryTransport(http.DefaultTransport)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return sleepContext(ctx, d)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	transport := newRetryTransport(http.DefaultTransport)
+	for attempt := 1; attempt <= 10; attempt++ {
+		want := min(transport.baseDelay<<(attempt-1), transport.maxDelay)
+		got := transport.backoff(attempt)
+		assert.GreaterOrEqual(t, got, want/2)
+		assert.LessOrEqual(t, got, want)
+	}
+}
+
+func TestIdempotencyTransport(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var mux sync.Mutex
+	keys := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		keys[r.URL.Path] = append(keys[r.URL.Path], r.Header.Get("Idempotency-Key"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &idempotencyTransport{next: http.DefaultTransport}}
+	for _, body := range []string{"status=a", "status=a", "status=b"} {
+		for _, path := range []string{"/api/v1/statuses", "/api/v1/media"} {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+path, strings.NewReader(body))
+			require.NoError(err)
+			resp, err := client.Do(req)
+			require.NoError(err)
+			assert.NoError(resp.Body.Close())
+		}
+	}
+
+	statusKeys := keys["/api/v1/statuses"]
+	require.Len(statusKeys, 3)
+	assert.NotEmpty(statusKeys[0])
+	assert.Equal(statusKeys[0], statusKeys[1], "same status should have the same key")
+	assert.NotEqual(statusKeys[0], statusKeys[2])
+	assert.Equal([]string{"", "", ""}, keys["/api/v1/media"])
+}
+
+func TestShouldRetryNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	addr := server.URL
+	server.Close()
+
+	transport := newRetryTransport(http.DefaultTransport)
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, err := http.NewRequestWithContext(context.Background(), method, addr, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		require.Error(t, err)
+		_, retry := transport.shouldRetry(req, nil, err)
+		assert.True(t, retry, "connection errors should be retried for %s", method)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, addr, nil)
+	require.NoError(t, err)
+	_, retry := transport.shouldRetry(req, nil, errors.New("invalid request"))
+	assert.False(t, retry)
+}
+
+func status(code int) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) { w.WriteHeader(code) }
+}
+
+func withHeader(respond func(http.ResponseWriter), key, value string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set(key, value)
+		respond(w)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretFileSuffix marks settings and environment variables that hold the
+// path of a file containing the secret instead of the secret itself.
+const secretFileSuffix = "_file"
+
+// readSecretFile reads a secret from a file. A trailing newline is removed.
+func readSecretFile(path string) (string, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	secret := strings.TrimRight(string(f), "\r\n")
+	if strings.TrimSpace(secret) == "" {
+		return "", fmt.Errorf("secret file %q is empty", path)
+	}
+	return secret, nil
+}
+
+// readCredential reads a systemd credential passed with LoadCredential= or
+// SetCredential=. It returns false if no such credential exists.
+func readCredential(lookupEnv func(string) (string, bool), name string) (string, bool, error) {
+	dir, ok := lookupEnv("CREDENTIALS_DIRECTORY")
+	if !ok || dir == "" {
+		return "", false, nil
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	secret, err := readSecretFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("reading credential %q: %w", name, err)
+	}
+	return secret, true, nil
+}
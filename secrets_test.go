@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	testCases := map[string]struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		"plain":            {path: write("plain", "s3cret"), want: "s3cret"},
+		"trailing newline": {path: write("newline", "s3cret\n"), want: "s3cret"},
+		"empty":            {path: write("empty", ""), wantErr: true},
+		"only newline":     {path: write("blank", "\n"), wantErr: true},
+		"missing":          {path: filepath.Join(dir, "missing"), wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := readSecretFile(tc.path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	credentials := filepath.Join(dir, "credentials")
+	require.NoError(t, os.Mkdir(credentials, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(credentials, "mastodon.access_token"), []byte("credential-token\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(credentials, "bluesky.app_password"), nil, 0o600))
+
+	configPath := write("config.yaml", `
+source: result
+maxPosts: 2
+accounts:
+  - platform: mastodon
+    settings:
+      server: https://techhub.social/
+      client_id: id
+      client_secret_file: `+write("client-secret", "file-secret\n")+`
+`)
+
+	t.Run("files and credentials", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		cfg, err := loadConfig(configPath, lookupMap(map[string]string{
+			"CREDENTIALS_DIRECTORY": credentials,
+		}))
+		require.NoError(err)
+		require.Len(cfg.Accounts, 1)
+		assert.Equal(map[string]string{
+			"server":        "https://techhub.social/",
+			"client_id":     "id",
+			"client_secret": "file-secret",
+			"access_token":  "credential-token",
+		}, cfg.Accounts[0].Settings)
+	})
+
+	t.Run("environment file", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		cfg, err := loadConfig(configPath, lookupMap(map[string]string{
+			"HMNB_MASTODON_CLIENT_SECRET_FILE": write("env-secret", "env-secret"),
+			"HMNB_MASTODON_ACCESS_TOKEN":       "env-token",
+		}))
+		require.NoError(err)
+		assert.Equal("env-secret", cfg.Accounts[0].Settings["client_secret"])
+		assert.Equal("env-token", cfg.Accounts[0].Settings["access_token"])
+	})
+
+	t.Run("unreadable file", func(t *testing.T) {
+		_, err := loadConfig(configPath, lookupMap(map[string]string{
+			"HMNB_MASTODON_CLIENT_SECRET_FILE": filepath.Join(dir, "missing"),
+		}))
+		assert.ErrorContains(t, err, "client_secret_file")
+	})
+
+	t.Run("empty credential", func(t *testing.T) {
+		_, err := loadConfig(configPath, lookupMap(map[string]string{
+			"CREDENTIALS_DIRECTORY": credentials,
+			"HMNB_BLUESKY_HANDLE":   "hmnews.bsky.social",
+		}))
+		assert.ErrorContains(t, err, "bluesky.app_password")
+	})
+}
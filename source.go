@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// newsSource provides the Home Manager news entries.
+type newsSource interface {
+	News(ctx context.Context) ([]newsEntry, error)
+	String() string
+}
+
+// parseNewsSource selects a news source from its specification:
+//
+//	-, stdin           news.json read from stdin
+//	file:PATH, PATH    news.json read from a file
+//	cmd:COMMAND        news.json printed by a shell command
+//	http(s)://...      news.json downloaded from a URL
+//	hm:PATH            news files of a Home Manager checkout
+func parseNewsSource(spec string, conf newsSourceConfig) (newsSource, error) {
+	switch {
+	case spec == "":
+		return nil, errors.New("empty news source")
+	case spec == "-" || spec == "stdin":
+		return &readerNewsSource{r: os.Stdin, name: "stdin"}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return &fileNewsSource{path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "cmd:"):
+		return &commandNewsSource{command: strings.TrimPrefix(spec, "cmd:")}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &httpNewsSource{url: spec, client: newRetryClient(), cacheDir: conf.cacheDir}, nil
+	case strings.HasPrefix(spec, "hm:"):
+		return &homeManagerNewsSource{root: strings.TrimPrefix(spec, "hm:"), system: conf.homeManagerSystem}, nil
+	default:
+		return &fileNewsSource{path: spec}, nil
+	}
+}
+
+type newsSourceConfig struct {
+	// cacheDir is where HTTP responses are cached. Caching is disabled if empty.
+	cacheDir string
+	// homeManagerSystem is the system conditions of a Home Manager checkout
+	// are evaluated for.
+	homeManagerSystem string
+}
+
+func parseNewsJSON(data []byte) ([]newsEntry, error) {
+	var newsFile newsFile
+	if err := json.Unmarshal(data, &newsFile); err != nil {
+		return nil, fmt.Errorf("unmarshaling news file: %w", err)
+	}
+	return newsFile.Entries, nil
+}
+
+type fileNewsSource struct {
+	path string
+}
+
+func (s *fileNewsSource) News(context.Context) ([]newsEntry, error) {
+	f, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file at %q: %w", s.path, err)
+	}
+	return parseNewsJSON(f)
+}
+
+func (s *fileNewsSource) String() string {
+	return "file " + s.path
+}
+
+type readerNewsSource struct {
+	r    io.Reader
+	name string
+}
+
+func (s *readerNewsSource) News(context.Context) ([]newsEntry, error) {
+	f, err := io.ReadAll(s.r)
+	if err != nil {
+		return nil, fmt.Errorf("reading from %s: %w", s.name, err)
+	}
+	return parseNewsJSON(f)
+}
+
+func (s *readerNewsSource) String() string {
+	return s.name
+}
+
+type commandNewsSource struct {
+	command string
+}
+
+func (s *commandNewsSource) News(ctx context.Context) ([]newsEntry, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %q: %w", s.command, err)
+	}
+	return parseNewsJSON(out)
+}
+
+func (s *commandNewsSource) String() string {
+	return "command " + s.command
+}
+
+type homeManagerNewsSource struct {
+	root   string
+	system string
+}
+
+func (s *homeManagerNewsSource) News(context.Context) ([]newsEntry, error) {
+	return readHomeManagerNews(s.root, s.system)
+}
+
+func (s *homeManagerNewsSource) String() string {
+	return "Home Manager checkout " + s.root
+}
+
+// httpNewsSource downloads the news file. If a cache directory is configured,
+// the last response is kept and revalidated with ETag and If-Modified-Since.
+type httpNewsSource struct {
+	url      string
+	client   *http.Client
+	cacheDir string
+}
+
+type httpCacheMeta struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+}
+
+func (s *httpNewsSource) News(ctx context.Context) ([]newsEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	cached, meta := s.readCache()
+	if cached != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", s.url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, fmt.Errorf("fetching %s: not modified, but no cached response", s.url)
+		}
+		log.Printf("News at %s not modified, using cached response", s.url)
+		return parseNewsJSON(cached)
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", s.url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	news, err := parseNewsJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	s.writeCache(body, httpCacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	return news, nil
+}
+
+func (s *httpNewsSource) String() string {
+	return "URL " + s.url
+}
+
+func (s *httpNewsSource) cachePath() string {
+	sum := sha256.Sum256([]byte(s.url))
+	return filepath.Join(s.cacheDir, "news-"+hex.EncodeToString(sum[:8]))
+}
+
+func (s *httpNewsSource) readCache() ([]byte, httpCacheMeta) {
+	var meta httpCacheMeta
+	if s.cacheDir == "" {
+		return nil, meta
+	}
+	body, err := os.ReadFile(s.cachePath() + ".json")
+	if err != nil {
+		return nil, meta
+	}
+	metaFile, err := os.ReadFile(s.cachePath() + ".meta.json")
+	if err != nil {
+		return nil, meta
+	}
+	if err := json.Unmarshal(metaFile, &meta); err != nil {
+		return nil, meta
+	}
+	return body, meta
+}
+
+// writeCache stores the response. Failures only disable caching, so they
+// are logged and otherwise ignored.
+func (s *httpNewsSource) writeCache(body []byte, meta httpCacheMeta) {
+	if s.cacheDir == "" || (meta.ETag == "" && meta.LastModified == "") {
+		return
+	}
+	metaFile, err := json.Marshal(meta)
+	if err != nil {
+		log.Printf("Warn: marshaling news cache metadata: %v", err)
+		return
+	}
+	if err := os.MkdirAll(s.cacheDir, 0o755); err != nil {
+		log.Printf("Warn: creating news cache directory: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.cachePath()+".json", body, 0o644); err != nil {
+		log.Printf("Warn: writing news cache: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.cachePath()+".meta.json", metaFile, 0o644); err != nil {
+		log.Printf("Warn: writing news cache metadata: %v", err)
+	}
+}
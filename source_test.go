@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNewsFile = "testdata/2025-05-15T21:37:58/news.json"
+
+func TestParseNewsSource(t *testing.T) {
+	testCases := map[string]struct {
+		spec    string
+		want    newsSource
+		wantErr bool
+	}{
+		"stdin":       {spec: "-", want: &readerNewsSource{r: os.Stdin, name: "stdin"}},
+		"file prefix": {spec: "file:news.json", want: &fileNewsSource{path: "news.json"}},
+		"plain path":  {spec: "result", want: &fileNewsSource{path: "result"}},
+		"command":     {spec: "cmd:nix build --print-out-paths", want: &commandNewsSource{command: "nix build --print-out-paths"}},
+		"url":         {spec: "https://example.com/news.json", want: &httpNewsSource{url: "https://example.com/news.json", cacheDir: "/cache"}},
+		"checkout":    {spec: "hm:/src/home-manager", want: &homeManagerNewsSource{root: "/src/home-manager", system: "x86_64-linux"}},
+		"empty":       {spec: "", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseNewsSource(tc.spec, newsSourceConfig{cacheDir: "/cache", homeManagerSystem: "x86_64-linux"})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if s, ok := got.(*httpNewsSource); ok {
+				// The client retries, which isn't comparable.
+				assert.NotNil(t, s.client)
+				s.client = nil
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNewsSources(t *testing.T) {
+	f, err := os.ReadFile(testNewsFile)
+	require.NoError(t, err)
+
+	testCases := map[string]newsSource{
+		"file":    &fileNewsSource{path: testNewsFile},
+		"reader":  &readerNewsSource{r: strings.NewReader(string(f)), name: "test"},
+		"command": &commandNewsSource{command: "cat " + testNewsFile},
+	}
+	for name, source := range testCases {
+		t.Run(name, func(t *testing.T) {
+			news, err := source.News(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, news, 233)
+		})
+	}
+
+	t.Run("failing command", func(t *testing.T) {
+		_, err := (&commandNewsSource{command: "exit 1"}).News(context.Background())
+		assert.Error(t, err)
+	})
+}
+
+func TestHTTPNewsSource(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f, err := os.ReadFile(testNewsFile)
+	require.NoError(err)
+
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write(f)
+	}))
+	t.Cleanup(server.Close)
+
+	source := &httpNewsSource{url: server.URL, client: server.Client(), cacheDir: t.TempDir()}
+	for range 2 {
+		news, err := source.News(context.Background())
+		require.NoError(err)
+		assert.Len(news, 233)
+	}
+	assert.Equal(2, requests)
+	assert.Equal(1, notModified, "second request should be revalidated")
+
+	uncached := &httpNewsSource{url: server.URL, client: server.Client()}
+	news, err := uncached.News(context.Background())
+	require.NoError(err)
+	assert.Len(news, 233)
+	assert.Equal(1, notModified, "requests without cache must not be conditional")
+}
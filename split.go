@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// graphemeLen counts the user-perceived characters of the text, which is how
+// Bluesky measures the length of a post.
+func graphemeLen(text string) int {
+	return uniseg.GraphemeClusterCount(text)
+}
+
+// mastodonURLLen is the length Mastodon counts for a URL, no matter how long
+// the URL is.
+const mastodonURLLen = 23
+
+// mastodonURLRegexp approximates the URLs Mastodon recognizes in a post.
+// Trailing punctuation isn't part of the URL.
+var mastodonURLRegexp = regexp.MustCompile(`https?://\S*[^\s.,:;!?'"()\[\]<>]`)
+
+// mastodonLen measures the text like Mastodon: in graphemes, with each URL
+// counting as mastodonURLLen.
+func mastodonLen(text string) int {
+	var n, last int
+	for _, loc := range mastodonURLRegexp.FindAllStringIndex(text, -1) {
+		n += graphemeLen(text[last:loc[0]]) + mastodonURLLen
+		last = loc[1]
+	}
+	return n + graphemeLen(text[last:])
+}
+
+// breakPriority ranks the boundaries a message can be split at.
+type breakPriority int
+
+const (
+	breakNever breakPriority = iota - 1
+	breakWord
+	breakClause
+	breakSentence
+	breakListItem
+	breakParagraph
+)
+
+const (
+	// minPartFill is the share of its length a part is filled to before a
+	// stronger boundary is preferred over a boundary further on.
+	minPartFill = 0.5
+	// minLastPartFill is the share of its length the last part is filled
+	// to, if the boundary before it can be moved.
+	minLastPartFill = 0.25
+)
+
+// splitAtom is a piece of a message that is never split: a word, including
+// inline code spans with spaces, or a list marker with its first word.
+type splitAtom struct {
+	// start and end are the byte offsets of the atom in the message.
+	start, end int
+	// len is the length of the atom, gapLen the length of the whitespace
+	// after it.
+	len, gapLen int
+	// brk is the priority of the boundary after the atom.
+	brk breakPriority
+}
+
+// clientPosts renders the message for the client and splits it into posts.
+func clientPosts(c postingClient, message, hashTags string) []string {
+	return splitIntoPosts(c.RenderMessage(message), hashTags, c.MaxPostLen(), c.PostLen)
+}
+
+// splitIntoPosts splits the message into a thread of posts that are at most
+// maxPostLen long, as measured by postLen. The first post gets the hash tags.
+// Parts end at the strongest boundary that leaves them filled to at least
+// minPartFill, and URLs, code spans and list items aren't broken.
+func splitIntoPosts(message, hashTags string, maxPostLen int, postLen func(string) int) []string {
+	if strings.TrimSpace(message) == "" {
+		return nil
+	}
+
+	if postLen(message+hashTags) <= maxPostLen {
+		return []string{message + hashTags}
+	}
+
+	// The part markers take the same length in all parts, so the number of
+	// parts is guessed and corrected if more digits are needed.
+	var parts [][2]int
+	var atoms []splitAtom
+	for m := 9; ; m = m*10 + 9 {
+		budget := maxPostLen - postLen(fmt.Sprintf(" [%d/%d]", m, m))
+		atoms = splitAtoms(message, budget-postLen(hashTags), postLen)
+		parts = splitParts(atoms, budget-postLen(hashTags), budget)
+		if len(parts) <= m {
+			break
+		}
+	}
+
+	posts := make([]string, len(parts))
+	for i, part := range parts {
+		text := message[atoms[part[0]].start:atoms[part[1]-1].end]
+		posts[i] = fmt.Sprintf("%s [%d/%d]", text, i+1, len(parts))
+	}
+	posts[0] += hashTags
+	return posts
+}
+
+// splitAtoms splits the message into atoms. Atoms longer than maxLen are
+// split further, at spaces and at last between graphemes.
+func splitAtoms(message string, maxLen int, postLen func(string) int) []splitAtom {
+	atoms := scanAtoms(message, true)
+	for k := range atoms {
+		a := &atoms[k]
+		a.len = postLen(message[a.start:a.end])
+		if k == len(atoms)-1 {
+			break
+		}
+		next := atoms[k+1]
+		gap := message[a.end:next.start]
+		a.gapLen = postLen(gap)
+		switch {
+		case isListMarker(message[a.start:a.end]):
+			a.brk = breakNever
+		case strings.Count(gap, "\n") >= 2:
+			a.brk = breakParagraph
+		case isListMarker(message[next.start:next.end]):
+			a.brk = breakListItem
+		default:
+			a.brk = punctuationBreak(message[a.start:a.end])
+		}
+	}
+
+	var fitting []splitAtom
+	for _, a := range atoms {
+		if a.len <= maxLen {
+			fitting = append(fitting, a)
+			continue
+		}
+		fitting = append(fitting, splitLongAtom(message, a, maxLen, postLen)...)
+	}
+	return fitting
+}
+
+// scanAtoms returns the offsets of the whitespace separated words of the
+// message. With codeSpans, the spaces of inline code spans don't separate
+// words.
+func scanAtoms(message string, codeSpans bool) []splitAtom {
+	var atoms []splitAtom
+	for i := 0; ; {
+		for i < len(message) && isSpaceAt(message, i) {
+			_, size := utf8.DecodeRuneInString(message[i:])
+			i += size
+		}
+		if i >= len(message) {
+			return atoms
+		}
+		start := i
+		for i < len(message) && !isSpaceAt(message, i) {
+			if codeSpans && message[i] == '`' {
+				i = codeSpanEnd(message, i)
+				continue
+			}
+			_, size := utf8.DecodeRuneInString(message[i:])
+			i += size
+		}
+		atoms = append(atoms, splitAtom{start: start, end: i})
+	}
+}
+
+// splitLongAtom splits an atom that is longer than maxLen into pieces that
+// fit, breaking code spans at their spaces and words between graphemes.
+func splitLongAtom(message string, a splitAtom, maxLen int, postLen func(string) int) []splitAtom {
+	var pieces []splitAtom
+	for _, w := range scanAtoms(message[a.start:a.end], false) {
+		w.start += a.start
+		w.end += a.start
+		pieces = append(pieces, cutWord(message, w, maxLen, postLen)...)
+	}
+	for i := range pieces {
+		p := &pieces[i]
+		p.len = postLen(message[p.start:p.end])
+		if i < len(pieces)-1 {
+			p.gapLen = postLen(message[p.end:pieces[i+1].start])
+			p.brk = breakWord
+		}
+	}
+	last := &pieces[len(pieces)-1]
+	last.gapLen, last.brk = a.gapLen, a.brk
+	return pieces
+}
+
+// cutWord cuts a word between graphemes into pieces that are at most maxLen
+// long.
+func cutWord(message string, w splitAtom, maxLen int, postLen func(string) int) []splitAtom {
+	var pieces []splitAtom
+	start := w.start
+	gr := uniseg.NewGraphemes(message[w.start:w.end])
+	for gr.Next() {
+		from, to := gr.Positions()
+		if w.start+from > start && postLen(message[start:w.start+to]) > maxLen {
+			pieces = append(pieces, splitAtom{start: start, end: w.start + from})
+			start = w.start + from
+		}
+	}
+	return append(pieces, splitAtom{start: start, end: w.end})
+}
+
+// splitParts groups the atoms into parts whose length is at most firstLen for
+// the first part and maxLen for the others. It returns the ranges of atoms of
+// the parts.
+func splitParts(atoms []splitAtom, firstLen, maxLen int) [][2]int {
+	// pos[k] is the length of the atoms before atom k, with their gaps.
+	pos := make([]int, len(atoms)+1)
+	for k, a := range atoms {
+		pos[k+1] = pos[k] + a.len + a.gapLen
+	}
+	length := func(s, e int) int {
+		return pos[e] - pos[s] - atoms[e-1].gapLen
+	}
+	budget := func(s int) int {
+		if s == 0 {
+			return firstLen
+		}
+		return maxLen
+	}
+
+	var parts [][2]int
+	for s := 0; s < len(atoms); {
+		limit := budget(s)
+		e := s + 1
+		for e < len(atoms) && length(s, e+1) <= limit {
+			e++
+		}
+		if e == len(atoms) {
+			parts = append(parts, [2]int{s, e})
+			break
+		}
+		end := bestBreak(atoms, s+1, e, func(g int) bool {
+			return float64(length(s, g)) >= minPartFill*float64(limit)
+		})
+		if end < 0 {
+			end = e
+		}
+		parts = append(parts, [2]int{s, end})
+		s = end
+	}
+
+	// Move the last boundary forward if it leaves the last part nearly empty.
+	if n := len(parts); n >= 2 && float64(length(parts[n-1][0], len(atoms))) < minLastPartFill*float64(maxLen) {
+		s := parts[n-2][0]
+		end := bestBreak(atoms, s+1, parts[n-1][0], func(g int) bool {
+			rest := length(g, len(atoms))
+			return length(s, g) <= budget(s) && rest <= maxLen && float64(rest) >= minLastPartFill*float64(maxLen)
+		})
+		if end >= 0 {
+			parts[n-2][1], parts[n-1][0] = end, end
+		}
+	}
+	return parts
+}
+
+// bestBreak returns the boundary with the highest priority in [from, to],
+// preferring the later one among equals. Boundaries for which ok returns
+// true are preferred over the others. Boundary g is the one after atom g-1.
+// It returns -1 if all boundaries are breakNever.
+func bestBreak(atoms []splitAtom, from, to int, ok func(g int) bool) int {
+	best, bestOK, bestBrk := -1, false, breakNever
+	for g := to; g >= from; g-- {
+		brk := atoms[g-1].brk
+		if brk == breakNever {
+			continue
+		}
+		gOK := ok(g)
+		if best < 0 || (gOK && !bestOK) || (gOK == bestOK && brk > bestBrk) {
+			best, bestOK, bestBrk = g, gOK, brk
+		}
+	}
+	return best
+}
+
+// punctuationBreak is the priority of the boundary after a word, by the
+// punctuation the word ends with.
+func punctuationBreak(word string) breakPriority {
+	word = strings.TrimRight(word, `'"’”)]`)
+	switch {
+	case strings.HasSuffix(word, "..."):
+		return breakClause
+	case strings.HasSuffix(word, "."), strings.HasSuffix(word, "!"), strings.HasSuffix(word, "?"):
+		return breakSentence
+	case strings.HasSuffix(word, ","), strings.HasSuffix(word, ";"), strings.HasSuffix(word, ":"):
+		return breakClause
+	}
+	return breakWord
+}
+
+// listMarkerRegexp matches the markers of list items.
+var listMarkerRegexp = regexp.MustCompile(`^([-*+•]|\d+[.)])$`)
+
+func isListMarker(word string) bool {
+	return listMarkerRegexp.MatchString(word)
+}
+
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+// codeSpanEnd returns the offset after the code span that starts with the
+// backticks at offset i, or after the backticks if they aren't closed.
+func codeSpanEnd(s string, i int) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == '`' {
+		n++
+	}
+	fence := strings.Repeat("`", n)
+	end := strings.Index(s[i+n:], fence)
+	if end < 0 {
+		return i + n
+	}
+	return i + n + end + n
+}
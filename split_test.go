@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestPostLen(t *testing.T) {
+	testCases := map[string]struct {
+		text         string
+		wantGrapheme int
+		wantMastodon int
+	}{
+		"ascii":             {text: "programs.git", wantGrapheme: 12, wantMastodon: 12},
+		"combining marks":   {text: "über", wantGrapheme: 4, wantMastodon: 4},
+		"typographic quote": {text: "it’s", wantGrapheme: 4, wantMastodon: 4},
+		"cjk":               {text: "日本語", wantGrapheme: 3, wantMastodon: 3},
+		"emoji sequences":   {text: "👩‍💻 🏳️‍🌈", wantGrapheme: 3, wantMastodon: 3},
+		"long url": {
+			text:         "see https://github.com/nix-community/home-manager/pull/8586 for more",
+			wantGrapheme: 68, wantMastodon: 4 + mastodonURLLen + 9,
+		},
+		"short url":            {text: "https://x.org", wantGrapheme: 13, wantMastodon: mastodonURLLen},
+		"trailing punctuation": {text: "(https://wiki.hypr.land/Configuring/Binds#submaps).", wantGrapheme: 51, wantMastodon: 1 + mastodonURLLen + 2},
+		"no scheme":            {text: "github.com/nix-community/home-manager", wantGrapheme: 37, wantMastodon: 37},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			assert.Equal(tc.wantGrapheme, graphemeLen(tc.text))
+			assert.Equal(tc.wantMastodon, mastodonLen(tc.text))
+		})
+	}
+}
+
+func TestSplitIntoPostsBoundaries(t *testing.T) {
+	testCases := map[string]struct {
+		message    string
+		maxPostLen int
+		// wantParts are the parts without their markers.
+		wantParts []string
+	}{
+		"sentence before words": {
+			message:    "The programs.foo module was removed. Use programs.bar, it has more options.",
+			maxPostLen: 70,
+			wantParts:  []string{"The programs.foo module was removed.", "Use programs.bar, it has more options."},
+		},
+		"clause before words": {
+			message:    "The module was renamed to programs.foo, so update your configuration accordingly",
+			maxPostLen: 70,
+			wantParts:  []string{"The module was renamed to programs.foo,", "so update your configuration accordingly"},
+		},
+		"paragraph before sentence": {
+			message:    "First paragraph. Still first.\n\nSecond paragraph that is long enough.",
+			maxPostLen: 60,
+			wantParts:  []string{"First paragraph. Still first.", "Second paragraph that is long enough."},
+		},
+		"code span": {
+			message:    "Configure the module with `programs.foo.settings = { bar = true; }`.",
+			maxPostLen: 50,
+			wantParts:  []string{"Configure the module with", "`programs.foo.settings = { bar = true; }`."},
+		},
+		"url": {
+			message:    "See https://github.com/nix-community/home-manager/pull/8586 for more details",
+			maxPostLen: 70,
+			wantParts:  []string{"See https://github.com/nix-community/home-manager/pull/8586", "for more details"},
+		},
+		"list items": {
+			message:    "New modules are available: - 'accounts.calendar', - 'accounts.contact', - 'programs.foo'",
+			maxPostLen: 70,
+			wantParts:  []string{"New modules are available: - 'accounts.calendar',", "- 'accounts.contact', - 'programs.foo'"},
+		},
+		"no nearly empty last part": {
+			message:    "One two three four five six seven eight nine ten eleven twelve thirteen.",
+			maxPostLen: 70,
+			wantParts:  []string{"One two three four five six seven eight nine ten eleven", "twelve thirteen."},
+		},
+		"word longer than a post": {
+			message:    "See " + strings.Repeat("x", 50) + " here",
+			maxPostLen: 30,
+			wantParts:  []string{"See", strings.Repeat("x", 24), strings.Repeat("x", 24), "xx here"},
+		},
+		"code span longer than a post": {
+			message:    "Set `" + strings.Repeat("programs.foo ", 6) + "`",
+			maxPostLen: 40,
+			wantParts:  []string{"Set `programs.foo programs.foo", "programs.foo programs.foo", "programs.foo programs.foo `"},
+		},
+		"more than nine parts": {
+			message:    strings.Repeat("Lorem ipsum dolor sit amet. ", 12),
+			maxPostLen: 40,
+			wantParts:  slices.Repeat([]string{"Lorem ipsum dolor sit amet."}, 12),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			posts := splitIntoPosts(tc.message, "", tc.maxPostLen, graphemeLen)
+			var parts []string
+			for i, post := range posts {
+				assert.LessOrEqual(graphemeLen(post), tc.maxPostLen, "post %d is too long", i)
+				marker := fmt.Sprintf(" [%d/%d]", i+1, len(posts))
+				assert.True(strings.HasSuffix(post, marker), "post %d should end with %q", i, marker)
+				parts = append(parts, strings.TrimSuffix(post, marker))
+			}
+			assert.Equal(tc.wantParts, parts)
+		})
+	}
+}
+
+func TestSplitIntoPostsGolden(t *testing.T) {
+	platforms := map[string]struct {
+		maxPostLen int
+		postLen    func(string) int
+	}{
+		// 500 is the default limit of Mastodon servers.
+		"mastodon": {maxPostLen: 500, postLen: mastodonLen},
+		"bluesky":  {maxPostLen: 300, postLen: graphemeLen},
+	}
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "split", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, inputs)
+	for _, input := range inputs {
+		for name, p := range platforms {
+			t.Run(fmt.Sprintf("%s,%s", filepath.Base(input), name), func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				message, err := os.ReadFile(input)
+				require.NoError(err)
+				posts := splitIntoPosts(string(message), hashTags, p.maxPostLen, p.postLen)
+
+				var got bytes.Buffer
+				for _, post := range posts {
+					assert.LessOrEqual(p.postLen(post), p.maxPostLen)
+					fmt.Fprintf(&got, "%s\n--- %d/%d\n", post, p.postLen(post), p.maxPostLen)
+				}
+
+				golden := strings.TrimSuffix(input, ".txt") + "." + name + ".golden"
+				if *updateGolden {
+					require.NoError(os.WriteFile(golden, []byte(got.String()), 0o644))
+				}
+				want, err := os.ReadFile(golden)
+				require.NoError(err)
+				assert.Equal(string(want), got.String())
+			})
+		}
+	}
+}
@@ -0,0 +1,9 @@
+{ config, ... }:
+{
+  time = "2025-04-30T10:00:00+00:00";
+  condition = config.services.screen-locker.enable && !config.services.xidlehook.enable;
+  message = ''
+    The option 'services.screen-locker.lockCmd' now expands ''${HOME}.
+      Write ''' for two single quotes.
+  '';
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+// removeStrategy is how an account handles news entries that were removed
+// from the news after they were posted.
+type removeStrategy string
+
+const (
+	// removeStrategyDelete deletes the posts of the entry.
+	removeStrategyDelete removeStrategy = "delete"
+	// removeStrategyReply replies to the thread of the entry with a
+	// retraction notice.
+	removeStrategyReply removeStrategy = "reply"
+	// removeStrategyReport only logs the posts of the entry.
+	removeStrategyReport removeStrategy = "report"
+)
+
+func (s removeStrategy) valid() bool {
+	switch s {
+	case removeStrategyDelete, removeStrategyReply, removeStrategyReport:
+		return true
+	}
+	return false
+}
+
+// retractionNotice is the reply to the threads of removed entries.
+const retractionNotice = "Retracted: this news entry was removed from Home Manager and no longer applies."
+
+// removedEntries returns the ledger records of the client whose entries are
+// no longer in the news. Records that share posts with the matched posts or
+// with the records of entries in the news aren't removed, their entries were
+// edited and got a new ID. Only entries posted within the list window are
+// considered, and none if the news are empty, as a broken news source could
+// otherwise withdraw all posts.
+func removedEntries(c postingClient, newsIDs, matched map[string]bool, postLedger ledger) []ledgerRecord {
+	if len(newsIDs) == 0 {
+		return nil
+	}
+	var cutoff time.Time
+	if c.ListWindow() > 0 {
+		cutoff = time.Now().Add(-c.ListWindow())
+	}
+	records := postLedger.Records(c.Name())
+	current := maps.Clone(matched)
+	if current == nil {
+		current = make(map[string]bool)
+	}
+	for _, record := range records {
+		if newsIDs[record.EntryID] {
+			for _, id := range record.PostIDs {
+				current[id] = true
+			}
+		}
+	}
+	var removed []ledgerRecord
+	for _, record := range records {
+		if newsIDs[record.EntryID] || record.WithdrawnAt != nil || len(record.PostIDs) == 0 ||
+			isBefore(record.PostedAt, cutoff) {
+			continue
+		}
+		if slices.ContainsFunc(record.PostIDs, func(id string) bool { return current[id] }) {
+			continue
+		}
+		removed = append(removed, record)
+	}
+	return removed
+}
+
+// withdrawRemovedEntries applies the remove strategy of the client to the
+// posts of removed entries, see removedEntries. At most MaxPosts entries are
+// withdrawn per run.
+func withdrawRemovedEntries(ctx context.Context, c postingClient, newsIDs, matched map[string]bool, postLedger ledger) error {
+	removed := removedEntries(c, newsIDs, matched, postLedger)
+	if s := c.RemoveStrategy(); s != removeStrategyDelete && s != removeStrategyReply {
+		for _, record := range removed {
+			log.Printf("Warn: news entry %s was removed, but its posts are still up: %v",
+				record.EntryID, slices.Concat(record.PostIDs, record.UpdateIDs))
+		}
+		return nil
+	}
+
+	for i, record := range removed {
+		postIDs := slices.Concat(record.PostIDs, record.UpdateIDs)
+		if i >= c.MaxPosts() {
+			log.Printf("Withdrawing %d more removed news entries in the next run", len(removed)-i)
+			break
+		}
+
+		switch c.RemoveStrategy() {
+		case removeStrategyDelete:
+			log.Printf("Deleting %d posts of removed news entry %s", len(postIDs), record.EntryID)
+			// Replies first, so that no post is left without its parent.
+			for _, id := range slices.Backward(postIDs) {
+				if err := c.DeletePost(ctx, id); err != nil {
+					return fmt.Errorf("deleting post %s of news entry %s: %w", id, record.EntryID, err)
+				}
+			}
+		case removeStrategyReply:
+			log.Printf("Retracting removed news entry %s", record.EntryID)
+			ids, err := c.CreatePostChain(ctx, []string{retractionNotice}, postIDs[len(postIDs)-1])
+			if err != nil {
+				return fmt.Errorf("retracting news entry %s: %w", record.EntryID, err)
+			}
+			record.UpdateIDs = slices.Concat(record.UpdateIDs, ids)
+		}
+
+		if c.DryRun() {
+			continue
+		}
+		withdrawnAt := time.Now().UTC()
+		record.WithdrawnAt = &withdrawnAt
+		if err := postLedger.Record(c.Name(), record); err != nil {
+			return fmt.Errorf("recording withdrawn news entry %s in ledger: %w", record.EntryID, err)
+		}
+	}
+	return nil
+}
+
+// newsIDs returns the set of the IDs of the news entries.
+func newsIDs(news []newsEntry) map[string]bool {
+	ids := make(map[string]bool, len(news))
+	for _, n := range news {
+		if n.ID != "" {
+			ids[n.ID] = true
+		}
+	}
+	return ids
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithdrawRemovedEntries(t *testing.T) {
+	now := time.Now().UTC()
+	withdrawnAt := now.Add(-time.Hour)
+	records := []ledgerRecord{
+		{EntryID: "kept", PostedAt: now, PostIDs: []string{"k1"}},
+		{EntryID: "removed", PostedAt: now, PostIDs: []string{"r1", "r2"}, UpdateIDs: []string{"r3"}},
+		{EntryID: "old", PostedAt: now.AddDate(0, 0, -200), PostIDs: []string{"o1"}},
+		{EntryID: "withdrawn", PostedAt: now, PostIDs: []string{"w1"}, WithdrawnAt: &withdrawnAt},
+	}
+
+	testCases := map[string]struct {
+		strategy      removeStrategy
+		news          []newsEntry
+		wantDeleted   []string
+		wantReplyTo   string
+		wantWithdrawn bool
+	}{
+		"delete": {
+			strategy:      removeStrategyDelete,
+			news:          []newsEntry{{ID: "kept"}},
+			wantDeleted:   []string{"r3", "r2", "r1"},
+			wantWithdrawn: true,
+		},
+		"reply": {
+			strategy:      removeStrategyReply,
+			news:          []newsEntry{{ID: "kept"}},
+			wantReplyTo:   "r3",
+			wantWithdrawn: true,
+		},
+		"report": {
+			strategy: removeStrategyReport,
+			news:     []newsEntry{{ID: "kept"}},
+		},
+		"no news": {
+			strategy: removeStrategyDelete,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			postLedger := newMemLedger()
+			for _, record := range records {
+				require.NoError(postLedger.Record("stub", record))
+			}
+			client := &stubPostingClient{removeStrategy: tc.strategy, listWindow: 97 * 24 * time.Hour}
+
+			require.NoError(withdrawRemovedEntries(context.Background(), client, newsIDs(tc.news), nil, postLedger))
+			assert.Equal(tc.wantDeleted, client.deletedPosts)
+			if tc.wantReplyTo != "" {
+				require.Len(client.createPostChainPosts, 1)
+				assert.Equal(retractionNotice, client.createPostChainPosts[0].Text())
+				assert.Equal(tc.wantReplyTo, client.createPostChainPosts[0].InReplyTo())
+			} else {
+				assert.Empty(client.createPostChainPosts)
+			}
+
+			record, ok := postLedger.Lookup("stub", "removed")
+			require.True(ok)
+			assert.Equal(tc.wantWithdrawn, record.WithdrawnAt != nil)
+			for _, id := range []string{"kept", "old"} {
+				record, ok := postLedger.Lookup("stub", id)
+				require.True(ok)
+				assert.Nil(record.WithdrawnAt, "%s shouldn't be withdrawn", id)
+			}
+		})
+	}
+}
+
+func TestWithdrawRemovedEntriesLimit(t *testing.T) {
+	require := require.New(t)
+
+	postLedger := newMemLedger()
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(postLedger.Record("stub", ledgerRecord{EntryID: id, PostedAt: time.Now(), PostIDs: []string{id}}))
+	}
+	client := &stubPostingClient{removeStrategy: removeStrategyDelete}
+	news := newsIDs([]newsEntry{{ID: "other"}})
+
+	require.NoError(withdrawRemovedEntries(context.Background(), client, news, nil, postLedger))
+	assert.Equal(t, []string{"a", "b"}, client.deletedPosts, "at most MaxPosts entries should be withdrawn")
+	require.NoError(withdrawRemovedEntries(context.Background(), client, news, nil, postLedger))
+	assert.Equal(t, []string{"a", "b", "c"}, client.deletedPosts)
+}
+
+func TestRemovedEntriesSkipsEditedEntries(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now().UTC()
+	postLedger := newMemLedger()
+	for _, record := range []ledgerRecord{
+		{EntryID: "old", PostedAt: now, PostIDs: []string{"1", "2"}},
+		{EntryID: "new", PostedAt: now, PostIDs: []string{"1", "2"}},
+		{EntryID: "matched", PostedAt: now, PostIDs: []string{"3"}},
+		{EntryID: "removed", PostedAt: now, PostIDs: []string{"4"}},
+	} {
+		require.NoError(postLedger.Record("stub", record))
+	}
+	client := &stubPostingClient{}
+	news := newsIDs([]newsEntry{{ID: "new"}})
+
+	removed := removedEntries(client, news, map[string]bool{"3": true}, postLedger)
+	require.Len(removed, 1, "records sharing posts with current entries should be kept")
+	assert.Equal(t, "removed", removed[0].EntryID)
+}
+
+func TestRunPostsWithdrawnEntryAgain(t *testing.T) {
+	withdrawnAt := time.Now()
+	postLedger := newMemLedger()
+	require.NoError(t, postLedger.Record("stub", ledgerRecord{EntryID: "three", PostIDs: []string{"1"}, WithdrawnAt: &withdrawnAt}))
+
+	threads := notInLedger([]newsThread{{entry: threeParts()}}, postLedger, "stub")
+	require.Len(t, threads, 1, "entry that was added back should be posted again")
+	assert.Empty(t, threads[0].postedIDs)
+	assert.Nil(t, threads[0].edit)
+}